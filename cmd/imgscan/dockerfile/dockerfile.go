@@ -2,7 +2,9 @@ package dockerfile
 
 import (
 	"github.com/urfave/cli/v2"
+	"imgscan/cmd/imgscan/flags"
 	"imgscan/internal/logger"
+	"runtime"
 )
 
 type dockerfileCommand struct {
@@ -13,8 +15,15 @@ type options struct {
 	ignoreFile         cli.StringSlice
 	ignoreRule         cli.StringSlice
 	customizedRuleFile cli.StringSlice
+	buildArg           cli.StringSlice
 	mode               string
 	outputFile         string
+	target             string
+	rulesDir           string
+	recursive          bool
+	jobs               int
+	showSecrets        bool
+	entropy            flags.EntropyOptions
 }
 
 // NewCommand constructs a dockerfile command with the specified logger
@@ -28,9 +37,10 @@ func NewCommand(logger logger.Interface) *cli.Command {
 func (m dockerfileCommand) build() *cli.Command {
 	opts := options{}
 	return &cli.Command{
-		Name:  "dockerfile",
-		Usage: "Scan the dockerfile to analyze",
-		Flags: []cli.Flag{
+		Name:      "dockerfile",
+		Usage:     "Scan the dockerfile to analyze",
+		ArgsUsage: "[file | directory | glob ...]",
+		Flags: append([]cli.Flag{
 			&cli.StringSliceFlag{
 				Name:        "ignore-file",
 				Usage:       "Ignore rules by using a file (remote url or file) that contains IDs of the default rules you want to ignore",
@@ -51,18 +61,52 @@ func (m dockerfileCommand) build() *cli.Command {
 			},
 			&cli.StringFlag{
 				Name:        "mode",
-				Usage:       "Using default rules [core, credentials, all (default value), none]",
+				Usage:       "Using default rules [core, credentials, shell, all (default value), none], or any other pack of the rules directories by its name",
 				Aliases:     []string{"m"},
 				Value:       "all",
 				Destination: &opts.mode,
 			},
+			&cli.StringFlag{
+				Name:        "rules-dir",
+				Usage:       "Read the default rule packs from this directory, packs it does not hold are looked up in IMGSCAN_RULES_PATH, then in the ones built into imgscan",
+				Destination: &opts.rulesDir,
+			},
 			&cli.StringFlag{
 				Name:        "output-file",
 				Usage:       "Export the analyze results as a json",
 				Aliases:     []string{"o"},
 				Destination: &opts.outputFile,
 			},
-		},
+			&cli.StringFlag{
+				Name:        "target",
+				Usage:       "Scan the Dockerfile as built for this target stage, the last stage by default",
+				Aliases:     []string{"t"},
+				Destination: &opts.target,
+			},
+			&cli.StringSliceFlag{
+				Name:        "build-arg",
+				Usage:       "Set a build argument as KEY=VALUE, the way docker build does, to resolve the variables of the Dockerfile",
+				Destination: &opts.buildArg,
+			},
+			&cli.BoolFlag{
+				Name:        "recursive",
+				Usage:       "Scan the Dockerfile, *.Dockerfile and Containerfile files found in the directories given as arguments",
+				Aliases:     []string{"r"},
+				Destination: &opts.recursive,
+			},
+			&cli.IntFlag{
+				Name:        "jobs",
+				Usage:       "Number of Dockerfiles scanned at the same time",
+				Aliases:     []string{"j"},
+				Value:       runtime.NumCPU(),
+				Destination: &opts.jobs,
+			},
+			&cli.BoolFlag{
+				Name:        "show-secrets",
+				Usage:       "Write the secrets found in full in the output file, instead of redacted",
+				Destination: &opts.showSecrets,
+			},
+		}, opts.entropy.Flags("Detect random strings such as tokens and keys by their entropy, reported as entropy-001 to entropy-003")...),
 		Action: func(c *cli.Context) error {
 			return m.analyze(c, &opts)
 		},
@@ -7,166 +7,220 @@ import (
 	"fmt"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
-	"gopkg.in/yaml.v2"
-	"io"
+	"imgscan/internal/parser"
+	"imgscan/internal/secrets"
+	"imgscan/rules"
 	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-// Rule represents a rule for Dockerfile analysis
-type Rule struct {
-	ID          string `yaml:"id" json:"id"`
-	Description string `yaml:"description" json:"description"`
-	Regex       string `yaml:"regex" json:"regex"`
-	Reference   string `yaml:"reference" json:"reference"`
-	Severity    string `yaml:"severity" json:"severity"`
+// Finding is a rule matched by an instruction of a Dockerfile
+type Finding struct {
+	rules.Rule
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	Instruction string `json:"instruction"`
+	// Stage is the name or index of the build stage, empty before the first FROM
+	Stage string `json:"stage"`
+	// Match is the secret found by a detector or by a rule setting verify
+	Match string `json:"match,omitempty"`
+	// Entropy is the Shannon entropy of Match, in bits per character, for the entropy detector
+	Entropy float64 `json:"entropy,omitempty"`
+	// Confidence is high when the offline check of the rule accepted Match, low when it did not
+	Confidence  string `json:"confidence,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// FileReport holds the results of a Dockerfile
+type FileReport struct {
+	Findings []Finding `json:"findings"`
+	// Suppressed are the findings accepted by imgscan:ignore comments
+	Suppressed      []SuppressedFinding `json:"suppressed"`
+	SuppressedCount int                 `json:"suppressed_count"`
+	// Error is set when the Dockerfile could not be read, parsed or evaluated
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the JSON output of the dockerfile command
+type Report struct {
+	// Files holds the results of each Dockerfile scanned, by the name findings are reported against
+	Files           map[string]*FileReport `json:"files"`
+	FindingCount    int                    `json:"finding_count"`
+	SuppressedCount int                    `json:"suppressed_count"`
+	ErrorCount      int                    `json:"error_count"`
+}
+
+// redact hides the secret of the finding, see secrets.Redact
+func (f *Finding) redact() {
+	if f.Match != "" {
+		f.Match = secrets.Redact(f.Match)
+	}
+}
+
+// Location returns the file:line of the instruction that matched the rule
+func (f Finding) Location() string {
+	return fmt.Sprintf("%s:%d", f.File, f.Line)
 }
 
 func (m dockerfileCommand) analyze(c *cli.Context, opts *options) error {
-	dockerfileContent, err := m.loadDockerfile(c)
+	sources, err := m.findDockerfiles(c, opts)
 	if err != nil {
-		m.logger.Errorf("%w", err)
+		m.logger.Errorf("%v", err)
 		return err
 	}
 
-	rules, err := m.loadRules(opts)
+	ruleSet, err := rules.Load(opts.mode, opts.rulesDir, opts.customizedRuleFile.Value())
 	if err != nil {
-		m.logger.Errorf("%w", err)
+		m.logger.Errorf("%v", err)
 		return err
 	}
 
 	ignoreIDs, err := m.loadIgnoreIDs(opts)
 	if err != nil {
-		m.logger.Errorf("%w", err)
+		m.logger.Errorf("%v", err)
 		return err
 	}
 
-	foundIssues := m.matchRules(dockerfileContent, rules, ignoreIDs)
-
-	if err := m.processResults(opts, foundIssues); err != nil {
-		m.logger.Errorf("%w", err)
+	ruleSet, err = rules.Usable(ruleSet, ignoreIDs)
+	if err != nil {
+		m.logger.Errorf("%v", err)
 		return err
 	}
 
-	return nil
-}
-
-func (m dockerfileCommand) loadDockerfile(c *cli.Context) (string, error) {
-	if c.Args().Len() > 0 {
-		filePath := c.Args().First()
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			return "", fmt.Errorf("failed to read Dockerfile: %w", err)
-		}
-		return string(content), nil
+	buildArgs, err := m.loadBuildArgs(opts)
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
 	}
 
-	stat, err := os.Stdin.Stat()
+	compiled, err := compileRules(ruleSet)
 	if err != nil {
-		return "", fmt.Errorf("failed to stat stdin: %w", err)
+		m.logger.Errorf("%v", err)
+		return err
 	}
 
-	if stat.Mode()&os.ModeCharDevice == 0 {
-		content, err := io.ReadAll(os.Stdin)
-		if err != nil {
-			return "", fmt.Errorf("failed to read from stdin: %w", err)
-		}
-		return string(content), nil
+	s := scanner{
+		compiled:  compiled,
+		target:    opts.target,
+		buildArgs: buildArgs,
+		ignoreIDs: ignoreIDs,
+		detector:  opts.entropy.Detector(),
 	}
+	results := m.scanAll(sources, s, opts.jobs)
 
-	return "", fmt.Errorf("dockerfile is needed")
-}
-
-func (m dockerfileCommand) loadRules(opts *options) ([]Rule, error) {
-	var rules []Rule
-	switch opts.mode {
-	case "none":
-		// No default rules
-	case "all", "core", "credentials":
-		ruleFiles := m.getDefaultRuleFiles(opts.mode)
-		for _, file := range ruleFiles {
-			r, err := m.loadRulesFromFile(file)
-			if err != nil {
-				return nil, err
-			}
-			rules = append(rules, r...)
-		}
-	default:
-		// Other modes mean no default rules
+	if err := m.processResults(opts, sources, results); err != nil {
+		m.logger.Errorf("%v", err)
+		return err
 	}
 
-	for _, file := range opts.customizedRuleFile.Value() {
-		if strings.HasPrefix(file, "http") {
-			r, err := m.loadRulesFromURL(file)
-			if err != nil {
-				return nil, err
-			}
-			rules = append(rules, r...)
-		} else {
-			r, err := m.loadRulesFromFile(file)
-			if err != nil {
-				return nil, err
-			}
-			rules = append(rules, r...)
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
 		}
 	}
-
-	return rules, nil
+	if failed > 0 {
+		return fmt.Errorf("failed to scan %d of %d Dockerfiles", failed, len(sources))
+	}
+	return nil
 }
 
-func (m dockerfileCommand) getDefaultRuleFiles(ruleType string) []string {
-	// Assuming rules are stored in a directory relative to the executable
-	here, _ := os.Getwd()
-	rulesDir := filepath.Join(here, "rules")
-
-	switch ruleType {
-	case "core":
-		return []string{filepath.Join(rulesDir, "core.yaml")}
-	case "credentials":
-		return []string{filepath.Join(rulesDir, "credentials.yaml")}
-	default:
-		return []string{
-			filepath.Join(rulesDir, "core.yaml"),
-			filepath.Join(rulesDir, "credentials.yaml"),
+// compileRules compiles the rules, once for every Dockerfile scanned
+func compileRules(ruleSet []rules.Rule) ([]*rules.Compiled, error) {
+	var compiled []*rules.Compiled
+	for _, rule := range ruleSet {
+		c, err := rules.Compile(rule)
+		if err != nil {
+			return nil, err
 		}
+		compiled = append(compiled, c)
 	}
+	return compiled, nil
 }
 
-func (m dockerfileCommand) loadRulesFromFile(filePath string) ([]Rule, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read rules file: %w", err)
-	}
+// scanner holds what every Dockerfile of a run is scanned with
+type scanner struct {
+	compiled []*rules.Compiled
+	// detector finds random strings, nil when entropy detection is turned off
+	detector  *secrets.EntropyDetector
+	target    string
+	buildArgs map[string]string
+	ignoreIDs map[string]bool
+}
 
-	var rules []Rule
-	if err := yaml.Unmarshal(content, &rules); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal rules: %w", err)
+// scanAll scans the Dockerfiles with at most jobs of them at a time, and returns
+// their results in the order of sources
+func (m dockerfileCommand) scanAll(sources []source, s scanner, jobs int) []*FileReport {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(sources) {
+		jobs = len(sources)
 	}
 
-	return rules, nil
+	results := make([]*FileReport, len(sources))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result, err := m.scanFile(sources[index], s)
+				if err != nil {
+					m.logger.Errorf("%v", err)
+					result = &FileReport{Findings: []Finding{}, Suppressed: []SuppressedFinding{}, Error: err.Error()}
+				}
+				results[index] = result
+			}
+		}()
+	}
+	for i := range sources {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
 }
 
-func (m dockerfileCommand) loadRulesFromURL(url string) ([]Rule, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download rules from URL: %w", err)
+// scanFile evaluates the compiled rules and the entropy detector against a Dockerfile,
+// and applies its suppression comments
+func (m dockerfileCommand) scanFile(src source, s scanner) (*FileReport, error) {
+	content := src.content
+	if content == nil {
+		var err error
+		content, err = os.ReadFile(src.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	content, err := io.ReadAll(resp.Body)
+	dockerfile, err := parser.Parse(bytes.NewReader(content))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read rules from response: %w", err)
+		return nil, fmt.Errorf("failed to parse %s: %w", src.name, err)
 	}
 
-	var rules []Rule
-	if err := yaml.Unmarshal(content, &rules); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal rules: %w", err)
+	foundIssues, err := m.matchRules(src.name, dockerfile, s.target, s.buildArgs, s.compiled)
+	if err != nil {
+		return nil, err
+	}
+	if s.detector != nil {
+		foundIssues = append(foundIssues, dedupeSecrets(foundIssues, detectSecrets(src.name, dockerfile, s.detector, s.ignoreIDs))...)
+		sort.SliceStable(foundIssues, func(i, j int) bool {
+			return foundIssues[i].Line < foundIssues[j].Line
+		})
 	}
 
-	return rules, nil
+	foundIssues, suppressed := parseSuppressions(dockerfile).suppress(foundIssues)
+	return &FileReport{
+		Findings:        append([]Finding{}, foundIssues...),
+		Suppressed:      append([]SuppressedFinding{}, suppressed...),
+		SuppressedCount: len(suppressed),
+	}, nil
 }
 
 func (m dockerfileCommand) loadIgnoreIDs(opts *options) (map[string]bool, error) {
@@ -230,43 +284,94 @@ func (m dockerfileCommand) loadIgnoreIDsFromURL(url string) ([]string, error) {
 	return ids, nil
 }
 
-func (m dockerfileCommand) matchRules(content string, rules []Rule, ignoreIDs map[string]bool) []Rule {
-	var foundIssues []Rule
-
-	for _, rule := range rules {
-		if ignoreIDs[rule.ID] {
-			continue
+// loadBuildArgs parses the --build-arg values, a KEY without a value takes the value
+// of the environment variable of the same name, as docker build does
+func (m dockerfileCommand) loadBuildArgs(opts *options) (map[string]string, error) {
+	buildArgs := make(map[string]string)
+	for _, arg := range opts.buildArg.Value() {
+		name, value, ok := strings.Cut(arg, "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid build argument %q", arg)
 		}
-
-		matched, err := regexp.MatchString(rule.Regex, content)
-		if err != nil {
-			m.logger.Errorf("failed to compile regex for rule %s: %v", rule.ID, err)
-			continue
+		if !ok {
+			value, ok = os.LookupEnv(name)
+			if !ok {
+				continue
+			}
 		}
+		buildArgs[name] = value
+	}
+	return buildArgs, nil
+}
+
+// matchRules evaluates the compiled rules against the Dockerfile, see rules.Evaluate
+func (m dockerfileCommand) matchRules(file string, dockerfile *parser.Dockerfile, target string, buildArgs map[string]string, compiled []*rules.Compiled) ([]Finding, error) {
+	matches, err := rules.Evaluate(dockerfile, compiled, target, buildArgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate rules on %s: %w", file, err)
+	}
 
-		if matched {
-			foundIssues = append(foundIssues, rule)
+	var foundIssues []Finding
+	for _, match := range matches {
+		finding := Finding{
+			Rule:        match.Rule,
+			File:        file,
+			Line:        match.Instruction.Range.Start,
+			Instruction: match.Instruction.Cmd,
+			Match:       match.Secret,
+			Confidence:  match.Confidence,
+		}
+		finding.Fingerprint = secrets.Fingerprint(finding.ID, file, strconv.Itoa(finding.Line))
+		if match.Stage != nil {
+			finding.Stage = match.Stage.String()
 		}
+		foundIssues = append(foundIssues, finding)
 	}
 
-	return foundIssues
+	sort.SliceStable(foundIssues, func(i, j int) bool {
+		return foundIssues[i].Line < foundIssues[j].Line
+	})
+	return foundIssues, nil
 }
 
-func (m dockerfileCommand) processResults(opts *options, foundIssues []Rule) error {
-	if len(foundIssues) == 0 {
-		m.logger.Infof("No sensitive issues found")
-	} else {
-		data := make([][]string, len(foundIssues))
-		for i, issue := range foundIssues {
-			data[i] = []string{issue.ID, issue.Description, issue.Severity}
+func (m dockerfileCommand) processResults(opts *options, sources []source, results []*FileReport) error {
+	report := Report{Files: make(map[string]*FileReport)}
+	var data [][]string
+	for i, result := range results {
+		report.Files[sources[i].name] = result
+		report.FindingCount += len(result.Findings)
+		report.SuppressedCount += result.SuppressedCount
+		if result.Error != "" {
+			report.ErrorCount++
+		}
+		if !opts.showSecrets {
+			for i := range result.Findings {
+				result.Findings[i].redact()
+			}
+			for i := range result.Suppressed {
+				result.Suppressed[i].redact()
+			}
 		}
+		for _, issue := range result.Findings {
+			data = append(data, []string{issue.Location(), issue.Stage, issue.Instruction, issue.ID, issue.Description, issue.Severity, issue.Confidence})
+		}
+	}
 
+	if len(data) == 0 {
+		m.logger.Infof("No sensitive issues found")
+	} else {
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Rule Id", "Description", "Severity"})
+		table.SetHeader([]string{"Location", "Stage", "Instruction", "Rule Id", "Description", "Severity", "Confidence"})
 		table.SetBorder(true)
 		table.AppendBulk(data)
 		table.Render()
 	}
+	if report.SuppressedCount > 0 {
+		m.logger.Infof("%d findings suppressed by imgscan:ignore comments", report.SuppressedCount)
+	}
+	if len(sources) > 1 {
+		m.logger.Infof("%d Dockerfiles scanned, %d findings", len(sources), report.FindingCount)
+	}
 
 	if outputFile := opts.outputFile; outputFile != "" {
 		file, err := os.Create(outputFile)
@@ -276,10 +381,76 @@ func (m dockerfileCommand) processResults(opts *options, foundIssues []Rule) err
 		defer file.Close()
 
 		encoder := json.NewEncoder(file)
-		if err := encoder.Encode(foundIssues); err != nil {
+		if err := encoder.Encode(report); err != nil {
 			return fmt.Errorf("failed to write issues to output file: %w", err)
 		}
 	}
 
 	return nil
 }
+
+// detectSecrets runs the entropy detector on the arguments and here-documents of every
+// instruction as written, but FROM whose digests are random by design
+func detectSecrets(file string, dockerfile *parser.Dockerfile, detector *secrets.EntropyDetector, ignoreIDs map[string]bool) []Finding {
+	stageOf := make(map[*parser.Instruction]*parser.Stage)
+	for _, stage := range dockerfile.Stages() {
+		for _, instruction := range stage.Instructions {
+			stageOf[instruction] = stage
+		}
+	}
+
+	var foundIssues []Finding
+	for _, instruction := range dockerfile.Instructions {
+		if instruction.Cmd == "FROM" {
+			continue
+		}
+		detect := func(text string, line int) {
+			for _, secret := range detector.Find(text) {
+				if ignoreIDs[secret.Kind.ID] {
+					continue
+				}
+				finding := Finding{
+					Rule: rules.Rule{
+						ID:          secret.Kind.ID,
+						Description: secret.Kind.Description,
+						Severity:    secret.Kind.Severity,
+					},
+					File:        file,
+					Line:        line + secret.Line - 1,
+					Instruction: instruction.Cmd,
+					Match:       secret.Value,
+					Entropy:     secret.Entropy,
+				}
+				finding.Fingerprint = secrets.Fingerprint(finding.ID, file, strconv.Itoa(finding.Line))
+				if stage := stageOf[instruction]; stage != nil {
+					finding.Stage = stage.String()
+				}
+				foundIssues = append(foundIssues, finding)
+			}
+		}
+		detect(instruction.Value, instruction.Range.Start)
+		for _, heredoc := range instruction.Heredocs {
+			detect(heredoc.Content, heredoc.Range.Start)
+		}
+	}
+	return foundIssues
+}
+
+// dedupeSecrets drops the secrets of the entropy detector that are part of a secret
+// a rule already reported, the rule tells more about them
+func dedupeSecrets(foundIssues []Finding, detected []Finding) []Finding {
+	var result []Finding
+	for _, finding := range detected {
+		known := false
+		for _, issue := range foundIssues {
+			if issue.Match != "" && strings.Contains(issue.Match, finding.Match) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			result = append(result, finding)
+		}
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+package dockerfile
+
+import (
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// STDIN_NAME is the name findings of a Dockerfile read from stdin are reported against
+const STDIN_NAME = "stdin"
+
+// source is a Dockerfile to scan, content is only set for stdin, files are read by the workers
+type source struct {
+	name    string
+	content []byte
+}
+
+// isDockerfile reports whether a file found walking a directory is a Dockerfile
+func isDockerfile(name string) bool {
+	return name == "Dockerfile" || name == "Containerfile" || strings.HasSuffix(name, ".Dockerfile")
+}
+
+// findDockerfiles returns the Dockerfiles named by the arguments, each one a file, a glob
+// or, with --recursive, a directory searched for Dockerfiles. Stdin is read without arguments
+func (m dockerfileCommand) findDockerfiles(c *cli.Context, opts *options) ([]source, error) {
+	if c.Args().Len() == 0 {
+		content, err := m.readStdin()
+		if err != nil {
+			return nil, err
+		}
+		return []source{{name: STDIN_NAME, content: content}}, nil
+	}
+
+	var sources []source
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			sources = append(sources, source{name: path})
+		}
+	}
+
+	for _, arg := range c.Args().Slice() {
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no file matches %s", arg)
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			if !opts.recursive {
+				return nil, fmt.Errorf("%s is a directory, use --recursive to scan the Dockerfiles it holds", path)
+			}
+			found, err := walkDockerfiles(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range found {
+				add(file)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// walkDockerfiles returns the Dockerfiles found under dir, in lexical order
+func walkDockerfiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && isDockerfile(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+func (m dockerfileCommand) readStdin() ([]byte, error) {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat stdin: %w", err)
+	}
+
+	if stat.Mode()&os.ModeCharDevice == 0 {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from stdin: %w", err)
+		}
+		return content, nil
+	}
+
+	return nil, fmt.Errorf("dockerfile is needed")
+}
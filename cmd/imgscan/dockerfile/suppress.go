@@ -0,0 +1,125 @@
+package dockerfile
+
+import (
+	"imgscan/internal/parser"
+	"regexp"
+	"strings"
+)
+
+var (
+	suppressRegexp = regexp.MustCompile(`^\s*imgscan:(ignore|ignore-file)\s+(.*)$`)
+	reasonRegexp   = regexp.MustCompile(`reason=("([^"]*)"|'([^']*)'|(\S+))`)
+)
+
+// SuppressedFinding is a finding accepted by an imgscan:ignore comment
+type SuppressedFinding struct {
+	Finding
+	Reason string `json:"reason"`
+	// SuppressedAt is the line of the comment suppressing the finding
+	SuppressedAt int `json:"suppressed_at"`
+}
+
+// suppression is an imgscan:ignore or imgscan:ignore-file comment
+type suppression struct {
+	ids    map[string]bool
+	reason string
+	line   int
+	// lines are the lines of the instruction an imgscan:ignore comment applies to
+	lines parser.Range
+}
+
+// suppressions are the inline suppressions of a Dockerfile
+type suppressions struct {
+	// instruction holds the imgscan:ignore comments, file the imgscan:ignore-file ones
+	instruction []suppression
+	file        []suppression
+}
+
+// parseSuppressions reads the suppression comments of a Dockerfile:
+//
+//	# imgscan:ignore core-004 reason="vendor tarball"
+//	# imgscan:ignore-file cred-001,cred-002
+//
+// imgscan:ignore applies to every line of the instruction below it, including its
+// continuation lines and here-documents, other comments and blank lines may sit in
+// between. imgscan:ignore-file applies to the whole file wherever it is written
+func parseSuppressions(dockerfile *parser.Dockerfile) *suppressions {
+	result := &suppressions{}
+
+	for _, comment := range dockerfile.Comments {
+		m := suppressRegexp.FindStringSubmatch(comment.Text)
+		if m == nil {
+			continue
+		}
+		s := suppression{ids: make(map[string]bool), line: comment.Line}
+		text := m[2]
+		if reason := reasonRegexp.FindStringSubmatch(text); reason != nil {
+			s.reason = reason[2] + reason[3] + reason[4]
+			text = strings.Replace(text, reason[0], "", 1)
+		}
+		for _, id := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			s.ids[id] = true
+		}
+		if len(s.ids) == 0 {
+			continue
+		}
+
+		if m[1] == "ignore-file" {
+			result.file = append(result.file, s)
+			continue
+		}
+		if instruction := instructionBelow(dockerfile, comment.Line); instruction != nil {
+			s.lines = instruction.Range
+			result.instruction = append(result.instruction, s)
+		}
+	}
+	return result
+}
+
+// instructionBelow returns the instruction following the comment on line, nil when the
+// comment sits inside an instruction, between its continuation lines, or ends the file.
+// Only comments and blank lines can sit between a comment and the next instruction
+func instructionBelow(dockerfile *parser.Dockerfile, line int) *parser.Instruction {
+	for _, instruction := range dockerfile.Instructions {
+		if instruction.Range.Start > line {
+			return instruction
+		}
+		if instruction.Range.End >= line {
+			return nil
+		}
+	}
+	return nil
+}
+
+// match returns the suppression accepting the finding, if any
+func (s *suppressions) match(finding Finding) (suppression, bool) {
+	for _, candidate := range s.instruction {
+		if candidate.ids[finding.ID] && finding.Line >= candidate.lines.Start && finding.Line <= candidate.lines.End {
+			return candidate, true
+		}
+	}
+	for _, candidate := range s.file {
+		if candidate.ids[finding.ID] {
+			return candidate, true
+		}
+	}
+	return suppression{}, false
+}
+
+// suppress splits the findings into the reported ones and the ones accepted by a suppression comment
+func (s *suppressions) suppress(findings []Finding) ([]Finding, []SuppressedFinding) {
+	var reported []Finding
+	var suppressed []SuppressedFinding
+	for _, finding := range findings {
+		if match, ok := s.match(finding); ok {
+			suppressed = append(suppressed, SuppressedFinding{
+				Finding:      finding,
+				Reason:       match.reason,
+				SuppressedAt: match.line,
+			})
+			continue
+		}
+		reported = append(reported, finding)
+	}
+	return reported, suppressed
+}
@@ -0,0 +1,74 @@
+// Package flags holds the command line flags shared by several imgscan commands
+package flags
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/internal/secrets"
+)
+
+// EntropyOptions are the entropy detector settings of the commands running it, set by
+// the flags returned by Flags
+type EntropyOptions struct {
+	// Enabled turns the entropy detector on, with the thresholds below
+	Enabled             bool
+	MinLength           int
+	Base64Threshold     float64
+	HexThreshold        float64
+	StandaloneThreshold float64
+}
+
+// Flags returns the --entropy flag, described by usage, and the threshold flags,
+// which default to secrets.DefaultEntropyConfig
+func (o *EntropyOptions) Flags(usage string) []cli.Flag {
+	defaults := secrets.DefaultEntropyConfig()
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "entropy",
+			Usage:       usage,
+			Value:       true,
+			Destination: &o.Enabled,
+		},
+		&cli.IntFlag{
+			Name:        "entropy-min-length",
+			Usage:       "Length a string must reach to be checked by the entropy detector",
+			Value:       defaults.MinLength,
+			Destination: &o.MinLength,
+		},
+		&cli.Float64Flag{
+			Name:        "entropy-base64-threshold",
+			Usage:       "Entropy, in bits per character, a base64 string assigned to a secret-like name must reach",
+			Value:       defaults.Base64Threshold,
+			Destination: &o.Base64Threshold,
+		},
+		&cli.Float64Flag{
+			Name:        "entropy-hex-threshold",
+			Usage:       "Entropy, in bits per character, a hex string assigned to a secret-like name must reach",
+			Value:       defaults.HexThreshold,
+			Destination: &o.HexThreshold,
+		},
+		&cli.Float64Flag{
+			Name:        "entropy-standalone-threshold",
+			Usage:       "Entropy, in bits per character, a base64 string without a secret-like name must reach",
+			Value:       defaults.StandaloneThreshold,
+			Destination: &o.StandaloneThreshold,
+		},
+	}
+}
+
+// Config returns the configuration of the entropy detector set by the options
+func (o *EntropyOptions) Config() secrets.EntropyConfig {
+	config := secrets.DefaultEntropyConfig()
+	config.MinLength = o.MinLength
+	config.Base64Threshold = o.Base64Threshold
+	config.HexThreshold = o.HexThreshold
+	config.StandaloneThreshold = o.StandaloneThreshold
+	return config
+}
+
+// Detector returns the entropy detector set by the options, nil when it is turned off
+func (o *EntropyOptions) Detector() *secrets.EntropyDetector {
+	if !o.Enabled {
+		return nil
+	}
+	return secrets.NewEntropyDetector(o.Config())
+}
@@ -9,6 +9,17 @@ type analyzeCommand struct {
 	logger logger.Interface
 }
 
+type options struct {
+	archive   string
+	ociLayout string
+	platform  string
+	// envRules is the env rules file replacing the default names and placeholders
+	envRules string
+	rulesDir string
+	// showSecrets shows the values of sensitive entries in full instead of redacted
+	showSecrets bool
+}
+
 // NewCommand constructs an analyze-command with the specified logger
 func NewCommand(logger logger.Interface) *cli.Command {
 	c := analyzeCommand{
@@ -18,11 +29,46 @@ func NewCommand(logger logger.Interface) *cli.Command {
 }
 
 func (m analyzeCommand) build() *cli.Command {
+	opts := options{}
 	return &cli.Command{
 		Name:  "analyze",
 		Usage: "Analyze sensitive information of the specified image",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "Analyze an image archive created by docker save instead of a local image",
+				Aliases:     []string{"a"},
+				Destination: &opts.archive,
+			},
+			&cli.StringFlag{
+				Name:        "oci-layout",
+				Usage:       "Analyze an image stored in an OCI image layout directory instead of a local image",
+				Destination: &opts.ociLayout,
+			},
+			&cli.StringFlag{
+				Name:        "platform",
+				Usage:       "Select the os/arch[/variant] image of a multi-arch OCI layout, required when it holds several images",
+				Aliases:     []string{"p"},
+				Destination: &opts.platform,
+			},
+			&cli.StringFlag{
+				Name:        "env-rules",
+				Usage:       "Read the names of the variables holding secrets and the placeholder values from this rules file (remote url or file)",
+				Destination: &opts.envRules,
+			},
+			&cli.StringFlag{
+				Name:        "rules-dir",
+				Usage:       "Read the credentials rule pack, applied to the values, from this directory, then from IMGSCAN_RULES_PATH, then from the one built into imgscan",
+				Destination: &opts.rulesDir,
+			},
+			&cli.BoolFlag{
+				Name:        "show-secrets",
+				Usage:       "Show the values of sensitive entries in full, instead of redacted",
+				Destination: &opts.showSecrets,
+			},
+		},
 		Action: func(c *cli.Context) error {
-			return m.analyze(c)
+			return m.analyze(c, &opts)
 		},
 	}
 }
@@ -5,98 +5,321 @@ import (
 	"fmt"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
+	"imgscan/internal/docker"
+	"imgscan/internal/parser"
+	"imgscan/internal/secrets"
 	"os"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-var sensitiveKeywords = map[string]struct{}{
-	"PASSWORD": {},
-	"PWD":      {},
-	"SECRET":   {},
-	"API_KEY":  {},
-	"TOKEN":    {},
-}
-
 // checkResults are used for table display
 type checkResults struct {
 	SensitiveEntry string
 	Description    string
+	// Secrets are the assignments shown by Description whose values are secrets
+	Secrets     []parser.Assignment
+	Fingerprint string
+}
+
+// redact hides the values of the secret assignments shown by the description of a
+// result, see secrets.Redact. Only the text following NAME= is replaced, so the same
+// characters elsewhere in the description, such as in a path, are left as they are
+func (r *checkResults) redact() {
+	for _, secret := range r.Secrets {
+		assignment := secret.Name + "=" + secret.Raw
+		r.Description = strings.ReplaceAll(r.Description, assignment, secret.Name+"="+secrets.Redact(secret.Raw))
+	}
 }
 
-// ImageInspect represents the structure of the JSON output from `docker inspect`
+// ImageInspect represents the structure of the image inspect answer of the Engine API,
+// it also decodes an image config blob since JSON keys match case-insensitively
 type ImageInspect struct {
-	Config struct {
-		User         string              `json:"User"`
-		Env          []string            `json:"Env"`
-		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
-	} `json:"Config"`
+	Config ImageConfig `json:"Config"`
+	// History is part of image config blobs, the Engine API inspect answer does not carry
+	// it and getImageInfo reads it from the history endpoint instead
+	History []ImageHistory `json:"history"`
+}
+
+// ImageConfig is the runtime configuration of an image
+type ImageConfig struct {
+	User         string              `json:"User"`
+	Env          []string            `json:"Env"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Cmd          []string            `json:"Cmd"`
+	Labels       map[string]string   `json:"Labels"`
+	Volumes      map[string]struct{} `json:"Volumes"`
+	WorkingDir   string              `json:"WorkingDir"`
+	Healthcheck  *Healthcheck        `json:"Healthcheck"`
+	OnBuild      []string            `json:"OnBuild"`
+	StopSignal   string              `json:"StopSignal"`
+}
+
+// Healthcheck is the health check of an image, durations are encoded in nanoseconds
+type Healthcheck struct {
+	Test        []string      `json:"Test"`
+	Interval    time.Duration `json:"Interval"`
+	Timeout     time.Duration `json:"Timeout"`
+	StartPeriod time.Duration `json:"StartPeriod"`
+	Retries     int           `json:"Retries"`
 }
 
-// Check for sensitive information in environment variables
-func (m analyzeCommand) hasSensitiveEnv(env []string) []checkResults {
+// ImageHistory is an entry of the image history, one per Dockerfile instruction
+type ImageHistory struct {
+	Created    string `json:"created"`
+	CreatedBy  string `json:"created_by"`
+	Comment    string `json:"comment"`
+	EmptyLayer bool   `json:"empty_layer"`
+}
+
+// Check for secrets in environment variables, each one split into its name and value
+func (m analyzeCommand) hasSensitiveEnv(env []string, checker *envChecker) []checkResults {
 	var results []checkResults
 	for _, e := range env {
-		if _, ok := sensitiveKeywords[strings.ToUpper(e)]; ok {
-			results = append(results, checkResults{SensitiveEntry: "Env", Description: e})
+		name, value, _ := strings.Cut(e, "=")
+		if reason, secret := checker.check(name, value); secret {
+			results = append(results, checkResults{
+				SensitiveEntry: "Env",
+				Description:    fmt.Sprintf("%s (%s)", e, reason),
+				Secrets:        []parser.Assignment{{Name: name, Value: value, Raw: value}},
+				Fingerprint:    secrets.Fingerprint("Env", "", name),
+			})
+		}
+	}
+	return results
+}
+
+// Check the labels for secrets, their names are checked the way environment variables are
+func (m analyzeCommand) hasSensitiveLabels(labels map[string]string, checker *envChecker) []checkResults {
+	var results []checkResults
+	for name, value := range labels {
+		if reason, secret := checker.check(labelKey(name), value); secret {
+			results = append(results, checkResults{
+				SensitiveEntry: "Labels",
+				Description:    fmt.Sprintf("%s=%s (%s)", name, value, reason),
+				Secrets:        []parser.Assignment{{Name: name, Value: value, Raw: value}},
+				Fingerprint:    secrets.Fingerprint("Labels", "", name),
+			})
 		}
 	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Description < results[j].Description
+	})
 	return results
 }
 
-// Get full image metadata using docker inspect
+// Check the history for instructions that recorded secrets, such as ENV and ARG values
+func (m analyzeCommand) hasSensitiveHistory(history []ImageHistory, checker *envChecker) []checkResults {
+	var results []checkResults
+	for i, entry := range history {
+		if assignments, reason := checker.checkAssignments(entry.CreatedBy); len(assignments) > 0 {
+			results = append(results, checkResults{
+				SensitiveEntry: "History",
+				Description:    fmt.Sprintf("%s (%s)", entry.CreatedBy, reason),
+				Secrets:        assignments,
+				Fingerprint:    secrets.Fingerprint("History", "", strconv.Itoa(i)),
+			})
+		}
+	}
+	return results
+}
+
+// Check the triggers that run in the builds of every image based on this one
+func (m analyzeCommand) hasOnBuild(onBuild []string) []checkResults {
+	var results []checkResults
+	for i, trigger := range onBuild {
+		results = append(results, checkResults{
+			SensitiveEntry: "OnBuild",
+			Description:    trigger,
+			Fingerprint:    secrets.Fingerprint("OnBuild", "", strconv.Itoa(i)),
+		})
+	}
+	return results
+}
+
+// Check that the health of containers can be monitored
+func (m analyzeCommand) checkHealthcheck(healthcheck *Healthcheck) []checkResults {
+	fingerprint := secrets.Fingerprint("Healthcheck", "", "")
+	if healthcheck == nil || len(healthcheck.Test) == 0 {
+		return []checkResults{{SensitiveEntry: "Healthcheck", Description: "no healthcheck configured", Fingerprint: fingerprint}}
+	}
+	if healthcheck.Test[0] == "NONE" {
+		return []checkResults{{SensitiveEntry: "Healthcheck", Description: "healthcheck disabled", Fingerprint: fingerprint}}
+	}
+	return nil
+}
+
+// engineHistory is an entry of the history answered by the Engine API
+type engineHistory struct {
+	Created   int64  `json:"Created"`
+	CreatedBy string `json:"CreatedBy"`
+	Comment   string `json:"Comment"`
+}
+
+// Get full image metadata from the Docker Engine API, with the history of the image
 func (m analyzeCommand) getImageInfo(imageIdentifier string) (*ImageInspect, error) {
-	cmd := exec.Command("docker", "inspect", imageIdentifier)
-	output, err := cmd.Output()
+	client, err := docker.NewEngineClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image metadata: %w", err)
+	}
+	output, err := client.ImageInspect(imageIdentifier)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image metadata: %w", err)
 	}
 
-	var inspectData []ImageInspect
+	var inspectData ImageInspect
 	if err := json.Unmarshal(output, &inspectData); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
 
-	if len(inspectData) == 0 {
-		return nil, fmt.Errorf("no data found for image: %s", imageIdentifier)
+	output, err = client.ImageHistory(imageIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image history: %w", err)
+	}
+	var history []engineHistory
+	if err := json.Unmarshal(output, &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	// The Engine API lists the newest entry first, image configs the oldest
+	for i := len(history) - 1; i >= 0; i-- {
+		inspectData.History = append(inspectData.History, ImageHistory{
+			Created:   time.Unix(history[i].Created, 0).UTC().Format(time.RFC3339),
+			CreatedBy: history[i].CreatedBy,
+			Comment:   history[i].Comment,
+		})
 	}
 
-	return &inspectData[0], nil
+	return &inspectData, nil
+}
+
+// Get the image config stored in an image archive or an OCI image layout
+func (m analyzeCommand) getConfigInfo(readConfig func() ([]byte, error)) (*ImageInspect, error) {
+	content, err := readConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image metadata: %w", err)
+	}
+
+	var config ImageInspect
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+
+	return &config, nil
 }
 
 // Analyze the image metadata for sensitive information
-func (m analyzeCommand) analyze(c *cli.Context) error {
-	if c.Args().Len() != 1 {
+func (m analyzeCommand) analyze(c *cli.Context, opts *options) error {
+	if opts.archive == "" && opts.ociLayout == "" && c.Args().Len() != 1 {
 		m.logger.Errorf("please check the parameters")
 	}
 
-	imageMetaData, err := m.getImageInfo(c.Args().First())
+	var imageMetaData *ImageInspect
+	var err error
+	switch {
+	case opts.ociLayout != "":
+		imageMetaData, err = m.getConfigInfo(func() ([]byte, error) {
+			return docker.ReadOCILayoutConfig(opts.ociLayout, opts.platform)
+		})
+	case opts.archive != "":
+		imageMetaData, err = m.getConfigInfo(func() ([]byte, error) {
+			return docker.ReadArchiveConfig(opts.archive)
+		})
+	default:
+		imageMetaData, err = m.getImageInfo(c.Args().First())
+	}
 	if err != nil {
 		m.logger.Errorf("%w", err)
 		return err
 	}
 
-	results := m.hasSensitiveEnv(imageMetaData.Config.Env)
+	checker, err := newEnvChecker(opts.envRules, opts.rulesDir, m.logger)
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+
+	results := m.hasSensitiveEnv(imageMetaData.Config.Env, checker)
 	if imageMetaData.Config.User == "" || imageMetaData.Config.User == "root" {
-		results = append(results, checkResults{SensitiveEntry: "User", Description: "root"})
+		results = append(results, checkResults{
+			SensitiveEntry: "User",
+			Description:    "root",
+			Fingerprint:    secrets.Fingerprint("User", "", ""),
+		})
 	}
 	for port := range imageMetaData.Config.ExposedPorts {
-		results = append(results, checkResults{SensitiveEntry: "Exposed Ports", Description: port})
+		results = append(results, checkResults{
+			SensitiveEntry: "Exposed Ports",
+			Description:    port,
+			Fingerprint:    secrets.Fingerprint("Exposed Ports", "", port),
+		})
+	}
+	for volume := range imageMetaData.Config.Volumes {
+		results = append(results, checkResults{
+			SensitiveEntry: "Volumes",
+			Description:    volume,
+			Fingerprint:    secrets.Fingerprint("Volumes", "", volume),
+		})
+	}
+	results = append(results, m.hasSensitiveLabels(imageMetaData.Config.Labels, checker)...)
+	results = append(results, m.hasOnBuild(imageMetaData.Config.OnBuild)...)
+	results = append(results, m.checkHealthcheck(imageMetaData.Config.Healthcheck)...)
+	results = append(results, m.hasSensitiveHistory(imageMetaData.History, checker)...)
+
+	redact := checker.redact
+	if opts.showSecrets {
+		redact = func(text string) string { return text }
 	}
+	printConfig(&imageMetaData.Config, redact)
 
 	if len(results) == 0 {
 		m.logger.Infof("No sensitive issues found")
 	} else {
 		data := make([][]string, len(results))
 		for i, issue := range results {
-			data[i] = []string{issue.SensitiveEntry, issue.Description}
+			if !opts.showSecrets {
+				issue.redact()
+			}
+			data[i] = []string{issue.SensitiveEntry, issue.Description, issue.Fingerprint}
 		}
 
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Sensitive Entry", "Description"})
+		table.SetHeader([]string{"Sensitive Entry", "Description", "Fingerprint"})
 		table.SetBorder(true)
 		table.AppendBulk(data)
 		table.Render()
 	}
 	return nil
 }
+
+// printConfig displays how containers of the image start, the commands passed through
+// redact since their arguments may hold secrets
+func printConfig(config *ImageConfig, redact func(string) string) {
+	var data [][]string
+	add := func(name, value string) {
+		if value != "" {
+			data = append(data, []string{name, value})
+		}
+	}
+	add("Entrypoint", redact(strings.Join(config.Entrypoint, " ")))
+	add("Cmd", redact(strings.Join(config.Cmd, " ")))
+	add("WorkingDir", config.WorkingDir)
+	add("User", config.User)
+	add("StopSignal", config.StopSignal)
+	if config.Healthcheck != nil && len(config.Healthcheck.Test) > 0 && config.Healthcheck.Test[0] != "NONE" {
+		add("Healthcheck", fmt.Sprintf("%s (interval %s, timeout %s, retries %d)",
+			redact(strings.Join(config.Healthcheck.Test[1:], " ")), config.Healthcheck.Interval,
+			config.Healthcheck.Timeout, config.Healthcheck.Retries))
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Config", "Value"})
+	table.SetBorder(true)
+	table.AppendBulk(data)
+	table.Render()
+}
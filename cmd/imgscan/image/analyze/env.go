@@ -0,0 +1,148 @@
+package analyze
+
+import (
+	"fmt"
+	"imgscan/internal/logger"
+	"imgscan/internal/parser"
+	"imgscan/internal/secrets"
+	"imgscan/rules"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// envHistoryRegexp matches the history entry of an ENV instruction, as recorded by
+	// the classic builder and by BuildKit
+	envHistoryRegexp = regexp.MustCompile(`^(/bin/sh -c #\(nop\)\s+)?ENV\s+`)
+	assignmentRegexp = regexp.MustCompile(`(?:^|\s)([A-Za-z_][A-Za-z0-9_.-]*)=`)
+)
+
+// envChecker tells the values of environment variables, labels and history entries
+// that are secrets, by the name they are assigned to and by the secret detectors
+type envChecker struct {
+	envRules rules.EnvRules
+	// compiled are the rules of the credentials pack that apply to a value on its own
+	compiled []*rules.Compiled
+	detector *secrets.EntropyDetector
+}
+
+// newEnvChecker loads the env rules, the default ones when envRulesFile is empty, and
+// the credentials rule pack, which must pass validation
+func newEnvChecker(envRulesFile, rulesDir string, logger logger.Interface) (*envChecker, error) {
+	c := &envChecker{
+		envRules: rules.DefaultEnvRules(),
+		detector: secrets.NewEntropyDetector(secrets.DefaultEntropyConfig()),
+	}
+	if envRulesFile != "" {
+		envRules, err := rules.LoadEnvRules(envRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		c.envRules = envRules
+	}
+
+	ruleSet, err := rules.Load("credentials", rulesDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet, err = rules.Usable(ruleSet, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range ruleSet {
+		compiled, err := rules.Compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		if compiled.AppliesToFiles() {
+			c.compiled = append(c.compiled, compiled)
+		}
+	}
+	return c, nil
+}
+
+// check returns why the value assigned to name is a secret, or false when it is not.
+// Placeholders are never secrets, then a rule of the credentials pack recognizing the
+// value wins over the name, and the entropy detector comes last
+func (c *envChecker) check(name, value string) (string, bool) {
+	if c.envRules.Placeholder(value) {
+		return "", false
+	}
+	for _, rule := range c.compiled {
+		for _, match := range rule.MatchFile(value) {
+			if match.Confidence != "" {
+				return fmt.Sprintf("%s %s, %s confidence", match.Rule.ID, match.Rule.Description, match.Confidence), true
+			}
+			return fmt.Sprintf("%s %s", match.Rule.ID, match.Rule.Description), true
+		}
+	}
+	if pattern := c.envRules.SensitiveKey(name); pattern != "" {
+		return fmt.Sprintf("name matches %s", pattern), true
+	}
+	if found := c.detector.Find(name + "=" + value); len(found) > 0 {
+		return fmt.Sprintf("%s, entropy %.1f", found[0].Kind.ID, found[0].Entropy), true
+	}
+	return "", false
+}
+
+// redact hides the secrets of a command, the values assigned to names holding secrets
+// and the random strings, see secrets.RedactText
+func (c *envChecker) redact(command string) string {
+	return secrets.RedactText(command, c.detector, func(name, value string) bool {
+		_, secret := c.check(name, value)
+		return secret
+	})
+}
+
+// checkAssignments returns the assignments of s to names holding secrets, like the ENV
+// and ARG values recorded in the image history, with the reason of the first one. Their
+// Raw values are as written, quotes included, so that they can be redacted in s
+func (c *envChecker) checkAssignments(s string) ([]parser.Assignment, string) {
+	var assignments []parser.Assignment
+	var reason string
+	for _, assignment := range historyAssignments(s) {
+		if why, secret := c.check(assignment.Name, assignment.Value); secret {
+			assignments = append(assignments, assignment)
+			if reason == "" {
+				reason = why
+			}
+		}
+	}
+	return assignments, reason
+}
+
+// historyAssignments returns the variables a history entry sets. The classic builder and
+// BuildKit record ENV values unquoted, ENV TOKEN=abc def, so a value runs up to the next
+// assignment there. Other entries, such as the build arguments of RUN, are split like
+// the words of ENV and ARG instructions
+func historyAssignments(createdBy string) []parser.Assignment {
+	loc := envHistoryRegexp.FindStringIndex(createdBy)
+	if loc == nil {
+		return parser.Assignments(createdBy)
+	}
+	rest := createdBy[loc[1]:]
+	starts := assignmentRegexp.FindAllStringSubmatchIndex(rest, -1)
+	var assignments []parser.Assignment
+	for i, start := range starts {
+		end := len(rest)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		// Raw keeps the text right after NAME=, which the redaction of the entry looks for
+		raw := strings.TrimRightFunc(rest[start[1]:end], unicode.IsSpace)
+		assignments = append(assignments, parser.Assignment{
+			Name:  rest[start[2]:start[3]],
+			Value: strings.Trim(strings.TrimSpace(raw), `"'`),
+			Raw:   raw,
+		})
+	}
+	return assignments
+}
+
+// labelKey turns a label name such as com.example.api-token into the name of an
+// environment variable, API_TOKEN, so that the env rules apply to it
+func labelKey(name string) string {
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
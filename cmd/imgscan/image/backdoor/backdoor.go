@@ -9,6 +9,14 @@ type backdoorCommand struct {
 	logger logger.Interface
 }
 
+type options struct {
+	archive   string
+	ociLayout string
+	platform  string
+	// showSecrets shows the file lines found in full instead of with their secrets redacted
+	showSecrets bool
+}
+
 // NewCommand constructs a backdoor-command with the specified logger
 func NewCommand(logger logger.Interface) *cli.Command {
 	c := backdoorCommand{
@@ -18,11 +26,36 @@ func NewCommand(logger logger.Interface) *cli.Command {
 }
 
 func (m backdoorCommand) build() *cli.Command {
+	opts := options{}
 	return &cli.Command{
 		Name:  "backdoor",
 		Usage: "Scan potential backdoor risks of the specified image",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "Scan an image archive created by docker save instead of a local image",
+				Aliases:     []string{"a"},
+				Destination: &opts.archive,
+			},
+			&cli.StringFlag{
+				Name:        "oci-layout",
+				Usage:       "Scan an image stored in an OCI image layout directory instead of a local image",
+				Destination: &opts.ociLayout,
+			},
+			&cli.StringFlag{
+				Name:        "platform",
+				Usage:       "Select the os/arch[/variant] image of a multi-arch OCI layout, required when it holds several images",
+				Aliases:     []string{"p"},
+				Destination: &opts.platform,
+			},
+			&cli.BoolFlag{
+				Name:        "show-secrets",
+				Usage:       "Show the file lines found in full, instead of with the secrets they hold redacted",
+				Destination: &opts.showSecrets,
+			},
+		},
 		Action: func(c *cli.Context) error {
-			return m.scanBackdoor(c)
+			return m.scanBackdoor(c, &opts)
 		},
 	}
 }
@@ -5,9 +5,11 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 	"imgscan/internal/docker"
-	"io"
+	"imgscan/internal/secrets"
+	"imgscan/rules"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
 	"unicode"
@@ -17,86 +19,155 @@ const (
 	ENV_BACKDOOR_DESCRIPTION  = "env backdoor"
 	CRON_BACKDOOR_DESCRIPTION = "cron job backdoor"
 	SSH_BACKDOOR_DESCRIPTION  = "ssh backdoor"
+	UNSAFE_ENTRY_DESCRIPTION  = "unsafe layer entry"
 )
 
+var (
+	// envFiles are the shell startup files checked for an env backdoor
+	envFiles = []string{
+		"/root/.bashrc", "/root/.bash_profile",
+		"/etc/bash.bashrc", "/etc/profile",
+	}
+	// envDirs are the directories whose files are all checked for an env backdoor
+	envDirs = []string{"/etc/profile.d"}
+	// homeDir holds the home directories of users, whose homeFiles are checked
+	homeDir   = "/home"
+	homeFiles = []string{".bashrc", ".profile"}
+	cronDirs  = []string{"/var/spool/cron/", "/etc/cron.d/"}
+)
+
+// checkedFile reports whether the file at name, relative to the image root, is one that
+// backdoorCheck reads
+func checkedFile(name string) bool {
+	for _, file := range envFiles {
+		if fsPath(file) == name {
+			return true
+		}
+	}
+	for _, dir := range append(envDirs, cronDirs...) {
+		if strings.HasPrefix(name, fsPath(dir)+"/") {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, fsPath(homeDir)+"/") && containsString(homeFiles, path.Base(name))
+}
+
 type BackdoorDetail struct {
 	FilePath    string
 	Content     string
 	Description string
+	// Layer is the layer that last wrote the file, if known
+	Layer       *docker.Layer
+	Fingerprint string
 }
 
-func (m backdoorCommand) scanBackdoor(c *cli.Context) error {
-	if c.Args().Len() != 1 {
+func (m backdoorCommand) scanBackdoor(c *cli.Context, opts *options) error {
+	if opts.archive == "" && opts.ociLayout == "" && c.Args().Len() != 1 {
 		m.logger.Errorf("please check the parameters")
 	}
 
-	dirPath, err := docker.ExtractImageLayers(c.Args().First())
+	imageFS, err := docker.OpenImage(docker.Source{
+		Image:     c.Args().First(),
+		Archive:   opts.archive,
+		OCILayout: opts.ociLayout,
+		Platform:  opts.platform,
+		Keep: func(name string, size int64, head []byte) bool {
+			return checkedFile(name)
+		},
+	})
 	if err != nil {
-		m.logger.Errorf(fmt.Sprintf("err extracting image layers: %v", err))
+		m.logger.Errorf(fmt.Sprintf("err opening image layers: %v", err))
+		return err
 	}
+	defer imageFS.Close()
 
-	results, err := backdoorCheck(dirPath)
+	results, err := backdoorCheck(imageFS)
 	if err != nil {
 		m.logger.Errorf(fmt.Sprintf("err scan image layers: %v", err))
 	}
+	results = append(results, unsafeEntryCheck(imageFS)...)
 	if len(results) == 0 {
 		m.logger.Infof("no backdoor found")
 	} else {
+		if !opts.showSecrets {
+			redactResults(results)
+		}
 		printResults(results)
 	}
 
-	err = os.RemoveAll(dirPath)
-	if err != nil {
-		m.logger.Errorf(fmt.Sprintf("err removing tempdir: %v", err))
+	return nil
+}
+
+// fsPath turns an absolute path of the image into a path of its fs.FS
+func fsPath(name string) string {
+	name = strings.TrimPrefix(path.Clean(name), "/")
+	if name == "" {
+		return "."
 	}
+	return name
+}
 
-	return nil
+// layerOf returns the layer that last wrote name, symlinks are followed so that
+// the layer which wrote the content is reported
+func layerOf(fsys fs.FS, name string) *docker.Layer {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil
+	}
+	return docker.LayerOf(info)
 }
 
-func backdoorCheck(dirPath string) ([]*BackdoorDetail, error) {
+func backdoorCheck(fsys docker.ReadLinkFS) ([]*BackdoorDetail, error) {
 	var backdoorDetails []*BackdoorDetail
 	var errMsg string
 
-	filePaths := []string{
-		"/root/.bashrc", "/root/.bash_profile",
-		"/etc/bash.bashrc", "/etc/profile",
-	}
-
-	for _, path := range filePaths {
-		fullPath := filepath.Join(dirPath, path)
-		err := checkFileForBackdoor(fullPath, dirPath, ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
+	for _, path := range envFiles {
+		err := checkFileForBackdoor(fsys, fsPath(path), ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	profileDir := "/etc/profile.d"
-	homeDir := "/home"
-	homeFiles := []string{".bashrc", ".profile"}
-
-	err := walkDirectoryForAllFiles(profileDir, dirPath, ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
-	if err != nil {
-		errMsg += fmt.Sprintf("%v ", err)
+	for _, dir := range envDirs {
+		err := walkDirectoryForAllFiles(fsys, dir, ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
+		if err != nil {
+			errMsg += fmt.Sprintf("%v ", err)
+		}
 	}
 
-	err = walkDirectoryForFiles(homeDir, dirPath, homeFiles, ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
+	err := walkDirectoryForFiles(fsys, homeDir, homeFiles, ENV_BACKDOOR_DESCRIPTION, &backdoorDetails)
 	if err != nil {
 		errMsg += fmt.Sprintf("%v ", err)
 	}
 
-	cronDir := []string{"/var/spool/cron/", "/etc/cron.d/"}
-	for _, cron := range cronDir {
-		err = walkDirectoryForAllFiles(cron, dirPath, CRON_BACKDOOR_DESCRIPTION, &backdoorDetails)
+	for _, cron := range cronDirs {
+		err = walkDirectoryForAllFiles(fsys, cron, CRON_BACKDOOR_DESCRIPTION, &backdoorDetails)
 		if err != nil {
 			errMsg += fmt.Sprintf("%v ", err)
 		}
 	}
 
-	sshdBackdoorCheck(dirPath, &backdoorDetails)
+	sshdBackdoorCheck(fsys, &backdoorDetails)
 
 	return backdoorDetails, nil
 }
 
+// unsafeEntryCheck reports the layer entries that tried to write outside of the image root,
+// a layer crafted that way is suspicious even though the entry was refused
+func unsafeEntryCheck(imageFS *docker.ImageFS) []*BackdoorDetail {
+	var backdoorDetails []*BackdoorDetail
+	for _, entry := range imageFS.UnsafeEntries {
+		backdoorDetails = append(backdoorDetails, &BackdoorDetail{
+			FilePath:    entry.Name,
+			Content:     entry.String(),
+			Description: UNSAFE_ENTRY_DESCRIPTION,
+			Layer:       entry.Layer,
+			Fingerprint: secrets.Fingerprint(UNSAFE_ENTRY_DESCRIPTION, entry.Name, entry.String()),
+		})
+	}
+	return backdoorDetails
+}
+
 func containsString(slice []string, str string) bool {
 	for _, v := range slice {
 		if v == str {
@@ -106,81 +177,89 @@ func containsString(slice []string, str string) bool {
 	return false
 }
 
-func sshdBackdoorCheck(dirPath string, backdoorDetails *[]*BackdoorDetail) {
+func sshdBackdoorCheck(fsys docker.ReadLinkFS, backdoorDetails *[]*BackdoorDetail) {
 	var checkList = []string{"su", "chsh", "chfn", "runuser"}
 	directoriesToCheck := []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin"}
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 
 	for _, dir := range directoriesToCheck {
+		// A symlinked directory, like /bin on merged /usr images, is skipped as its target is checked on its own
+		info, err := fsys.Lstat(fsPath(dir))
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
 		wg.Add(1)
 		go func(dir string) {
 			defer wg.Done()
 
-			filepath.Walk(filepath.Join(dirPath, dir), func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return nil
-				}
-				lstat, err := os.Lstat(path)
+			fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
 				if err != nil {
 					return nil
 				}
 
-				if lstat.Mode()&os.ModeSymlink == os.ModeSymlink {
-					fLink, err := os.Readlink(path)
+				if d.Type()&fs.ModeSymlink == fs.ModeSymlink {
+					fLink, err := fsys.ReadLink(name)
 					if err != nil {
 						return nil
 					}
 
-					fExeName := path[strings.LastIndex(path, "/")+1:]
+					fExeName := path.Base(name)
 					fLinkExeName := fLink[strings.LastIndex(fLink, "/")+1:]
 
 					if containsString(checkList, fExeName) && fLinkExeName == "sshd" {
+						var layer *docker.Layer
+						if info, err := d.Info(); err == nil {
+							layer = docker.LayerOf(info)
+						}
+
+						mu.Lock()
 						*backdoorDetails = append(*backdoorDetails, &BackdoorDetail{
-							FilePath:    path,
+							FilePath:    "/" + name,
 							Content:     fLink,
 							Description: SSH_BACKDOOR_DESCRIPTION,
+							Layer:       layer,
+							Fingerprint: secrets.Fingerprint(SSH_BACKDOOR_DESCRIPTION, "/"+name, ""),
 						})
+						mu.Unlock()
 					}
 				}
 				return nil
 			})
-		}(dir)
+		}(fsPath(dir))
 	}
 
 	wg.Wait()
 }
 
-func checkFileForBackdoor(filepath string, dirPath string, desc string, backdoorDetails *[]*BackdoorDetail) error {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil
-	}
-	defer file.Close()
-	contents, err := io.ReadAll(file)
+func checkFileForBackdoor(fsys fs.FS, name string, desc string, backdoorDetails *[]*BackdoorDetail) error {
+	contents, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		return nil
 	}
 	risk, content := analysisStrings(string(contents))
 	if risk {
 		*backdoorDetails = append(*backdoorDetails, &BackdoorDetail{
-			FilePath:    strings.TrimPrefix(filepath, dirPath),
+			FilePath:    "/" + name,
 			Content:     content,
 			Description: desc,
+			Layer:       layerOf(fsys, name),
+			Fingerprint: secrets.Fingerprint(desc, "/"+name, ""),
 		})
 	}
 	return nil
 }
 
-func walkDirectoryForFiles(dirPath string, prefix string, filesToCheck []string, desc string, backdoorDetails *[]*BackdoorDetail) error {
-	dirPath = filepath.Join(prefix, dirPath)
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+func walkDirectoryForFiles(fsys fs.FS, dirPath string, filesToCheck []string, desc string, backdoorDetails *[]*BackdoorDetail) error {
+	err := fs.WalkDir(fsys, fsPath(dirPath), func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
-		if !info.IsDir() {
+		if !d.IsDir() {
 			for _, filename := range filesToCheck {
-				if info.Name() == filename {
-					checkFileForBackdoor(path, prefix, desc, backdoorDetails)
+				if d.Name() == filename {
+					checkFileForBackdoor(fsys, name, desc, backdoorDetails)
 					break
 				}
 			}
@@ -190,14 +269,13 @@ func walkDirectoryForFiles(dirPath string, prefix string, filesToCheck []string,
 	return err
 }
 
-func walkDirectoryForAllFiles(dirPath string, prefix string, desc string, backdoorDetails *[]*BackdoorDetail) error {
-	dirPath = filepath.Join(prefix, dirPath)
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+func walkDirectoryForAllFiles(fsys fs.FS, dirPath string, desc string, backdoorDetails *[]*BackdoorDetail) error {
+	err := fs.WalkDir(fsys, fsPath(dirPath), func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
-			err := checkFileForBackdoor(path, prefix, desc, backdoorDetails)
+		if !d.IsDir() {
+			err := checkFileForBackdoor(fsys, name, desc, backdoorDetails)
 			if err != nil {
 				return err
 			}
@@ -207,15 +285,31 @@ func walkDirectoryForAllFiles(dirPath string, prefix string, desc string, backdo
 	return err
 }
 
+// redactResults hides the secrets of the lines found, such as the token of a download
+// or a password exported by a profile script, see secrets.RedactText
+func redactResults(results []*BackdoorDetail) {
+	envRules := rules.DefaultEnvRules()
+	detector := secrets.NewEntropyDetector(secrets.DefaultEntropyConfig())
+	sensitive := func(name, value string) bool {
+		return envRules.SensitiveKey(name) != "" && !envRules.Placeholder(value)
+	}
+	for _, result := range results {
+		result.Content = secrets.RedactText(result.Content, detector, sensitive)
+	}
+}
+
 func printResults(results []*BackdoorDetail) {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"File Path", "Description", "Content"})
+	table.SetHeader([]string{"File Path", "Description", "Content", "Layer", "Created By", "Fingerprint"})
 
 	for _, result := range results {
 		table.Append([]string{
 			result.FilePath,
 			result.Description,
 			result.Content,
+			result.Layer.String(),
+			result.Layer.Instruction(),
+			result.Fingerprint,
 		})
 	}
 	table.SetBorder(true)
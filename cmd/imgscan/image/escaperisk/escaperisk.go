@@ -9,6 +9,12 @@ type escaperiskCommand struct {
 	logger logger.Interface
 }
 
+type options struct {
+	archive   string
+	ociLayout string
+	platform  string
+}
+
 // NewCommand constructs an escaperisk-command with the specified logger
 func NewCommand(logger logger.Interface) *cli.Command {
 	c := escaperiskCommand{
@@ -18,11 +24,31 @@ func NewCommand(logger logger.Interface) *cli.Command {
 }
 
 func (m escaperiskCommand) build() *cli.Command {
+	opts := options{}
 	return &cli.Command{
 		Name:  "escaperisk",
 		Usage: "Scan potential escape risks of the specified image",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "Scan an image archive created by docker save instead of a local image",
+				Aliases:     []string{"a"},
+				Destination: &opts.archive,
+			},
+			&cli.StringFlag{
+				Name:        "oci-layout",
+				Usage:       "Scan an image stored in an OCI image layout directory instead of a local image",
+				Destination: &opts.ociLayout,
+			},
+			&cli.StringFlag{
+				Name:        "platform",
+				Usage:       "Select the os/arch[/variant] image of a multi-arch OCI layout, required when it holds several images",
+				Aliases:     []string{"p"},
+				Destination: &opts.platform,
+			},
+		},
 		Action: func(c *cli.Context) error {
-			return m.scanEscapeRisk(c)
+			return m.scanEscapeRisk(c, &opts)
 		},
 	}
 }
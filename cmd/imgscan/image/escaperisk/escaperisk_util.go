@@ -6,8 +6,9 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 	"imgscan/internal/docker"
+	"imgscan/internal/secrets"
+	"io/fs"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -24,62 +25,98 @@ type EscapeRiskDetail struct {
 	Target string
 	Reason string
 	Detail string
+	// Layer is the layer that last wrote the target file, if known
+	Layer       *docker.Layer
+	Fingerprint string
 }
 
-func (m escaperiskCommand) scanEscapeRisk(c *cli.Context) error {
-	if c.Args().Len() != 1 {
+func (m escaperiskCommand) scanEscapeRisk(c *cli.Context, opts *options) error {
+	if opts.archive == "" && opts.ociLayout == "" && c.Args().Len() != 1 {
 		m.logger.Errorf("please check the parameters")
 	}
 
-	dirPath, err := docker.ExtractImageLayers(c.Args().First())
+	imageFS, err := docker.OpenImage(docker.Source{
+		Image:     c.Args().First(),
+		Archive:   opts.archive,
+		OCILayout: opts.ociLayout,
+		Platform:  opts.platform,
+		Keep: func(name string, size int64, head []byte) bool {
+			return name == "etc/sudoers" || name == "etc/passwd" || name == "etc/shadow"
+		},
+	})
 	if err != nil {
-		m.logger.Errorf(fmt.Sprintf("err extracting image layers: %v", err))
+		m.logger.Errorf(fmt.Sprintf("err opening image layers: %v", err))
+		return err
 	}
+	defer imageFS.Close()
 
-	results := escapeRiskCheck(dirPath)
+	results := escapeRiskCheck(imageFS)
 	if len(results) == 0 {
 		m.logger.Infof("no backdoor found")
 	} else {
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Target", "Description", "Detail"})
+		table.SetHeader([]string{"Target", "Description", "Detail", "Layer", "Created By", "Fingerprint"})
 
 		for _, result := range results {
 			table.Append([]string{
 				result.Target,
 				result.Reason,
 				result.Detail,
+				result.Layer.String(),
+				result.Layer.Instruction(),
+				result.Fingerprint,
 			})
 		}
 		table.SetBorder(true)
 		table.Render()
 	}
 
-	err = os.RemoveAll(dirPath)
-	if err != nil {
-		m.logger.Errorf(fmt.Sprintf("err removing tempdir: %v", err))
-	}
-
 	return nil
 }
 
-func escapeRiskCheck(dirPath string) []*EscapeRiskDetail {
+func escapeRiskCheck(imageFS *docker.ImageFS) []*EscapeRiskDetail {
 	var escaperiskDetails []*EscapeRiskDetail
 
-	sudoFileCheck(dirPath, &escaperiskDetails)
-	unsafePrivCheck(dirPath, &escaperiskDetails)
-	checkEmptyPasswdRoot(dirPath, &escaperiskDetails)
+	sudoFileCheck(imageFS, &escaperiskDetails)
+	unsafePrivCheck(imageFS, &escaperiskDetails)
+	checkEmptyPasswdRoot(imageFS, &escaperiskDetails)
+	unsafeEntryCheck(imageFS, &escaperiskDetails)
 
+	for _, detail := range escaperiskDetails {
+		detail.Fingerprint = secrets.Fingerprint(detail.Reason, detail.Target, detail.Detail)
+	}
 	return escaperiskDetails
 }
 
-func sudoFileCheck(dirPath string, escaperiskDetails *[]*EscapeRiskDetail) {
+// unsafeEntryCheck reports the layer entries that tried to write outside of the image root
+func unsafeEntryCheck(imageFS *docker.ImageFS, escaperiskDetails *[]*EscapeRiskDetail) {
+	for _, entry := range imageFS.UnsafeEntries {
+		*escaperiskDetails = append(*escaperiskDetails, &EscapeRiskDetail{
+			Target: entry.Name,
+			Reason: "This layer entry tries to reach outside of the image root, it was refused or kept inside the root",
+			Detail: entry.String(),
+			Layer:  entry.Layer,
+		})
+	}
+}
+
+// layerOf returns the layer that last wrote the content of path
+func layerOf(fsys fs.FS, path string) *docker.Layer {
+	info, err := fs.Stat(fsys, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil
+	}
+	return docker.LayerOf(info)
+}
+
+func sudoFileCheck(fsys fs.FS, escaperiskDetails *[]*EscapeRiskDetail) {
 	unsafeSudoFiles := []string{
 		"wget", "find", "cat", "apt", "zip", "xxd", "time", "taskset", "git", "sed",
 		"pip", "ed", "tmux", "scp", "perl", "bash", "less", "awk", "man", "vi", "vim",
 		"env", "ftp", "all",
 	}
 
-	content, err := os.Open(filepath.Join(dirPath, "/etc/sudoers"))
+	content, err := fsys.Open("etc/sudoers")
 	if err != nil {
 		return
 	}
@@ -105,6 +142,7 @@ func sudoFileCheck(dirPath string, escaperiskDetails *[]*EscapeRiskDetail) {
 							Target: scanner.Text(),
 							Reason: "This file is granted sudo privileges and can be used for escalating,you can check it in /etc/sudoers",
 							Detail: "UnSafeUser " + matches[1],
+							Layer:  layerOf(fsys, "/etc/sudoers"),
 						})
 					}
 				}
@@ -113,13 +151,14 @@ func sudoFileCheck(dirPath string, escaperiskDetails *[]*EscapeRiskDetail) {
 	}
 }
 
-func privCheck(dirPath string, path string, checkMode checkMode) (string, bool, error) {
-	content, err := os.Stat(filepath.Join(dirPath, path))
+// privCheck reads the mode recorded in the image layers, symlinks are resolved inside the image
+func privCheck(fsys fs.FS, path string, checkMode checkMode) (string, bool, error) {
+	info, err := fs.Stat(fsys, strings.TrimPrefix(path, "/"))
 	if err != nil {
 		return "", false, err
 	}
 
-	mode := fmt.Sprintf("%o", uint32(content.Mode()))
+	mode := fmt.Sprintf("%o", uint32(info.Mode()))
 	privPasswdAllUsers, err := strconv.Atoi(string(mode[len(mode)-1]))
 	if err != nil {
 		return "", false, err
@@ -128,50 +167,52 @@ func privCheck(dirPath string, path string, checkMode checkMode) (string, bool,
 	// r: 4, w: 2, x: 1
 	if checkMode == WRITE {
 		if privPasswdAllUsers >= int(checkMode) && privPasswdAllUsers != 4 {
-			return content.Mode().String(), true, nil
+			return info.Mode().String(), true, nil
 		}
 	} else {
 		if privPasswdAllUsers >= int(checkMode) {
-			return content.Mode().String(), true, nil
+			return info.Mode().String(), true, nil
 		}
 	}
 	return "", false, nil
 }
 
-func unsafePrivCheck(dirPath string, escaperiskDetails *[]*EscapeRiskDetail) {
+func unsafePrivCheck(fsys fs.FS, escaperiskDetails *[]*EscapeRiskDetail) {
 	taskMap := make(map[checkMode][]string)
 	taskMap[WRITE] = []string{"/etc/passwd", "/etc/crontab"}
 	taskMap[READ] = []string{"/etc/shadow"}
 
 	for _, task := range taskMap[WRITE] {
-		if priv, ok, err := privCheck(dirPath, task, WRITE); err == nil {
+		if priv, ok, err := privCheck(fsys, task, WRITE); err == nil {
 			if ok {
 				*escaperiskDetails = append(*escaperiskDetails, &EscapeRiskDetail{
 					Target: task,
 					Reason: "This file is sensitive and is writable to all users",
 					Detail: "UnSafe privilege " + priv,
+					Layer:  layerOf(fsys, task),
 				})
 			}
 		}
 	}
 
 	for _, task := range taskMap[READ] {
-		if priv, ok, err := privCheck(dirPath, task, READ); err == nil {
+		if priv, ok, err := privCheck(fsys, task, READ); err == nil {
 			if ok {
 				*escaperiskDetails = append(*escaperiskDetails, &EscapeRiskDetail{
 					Target: task,
 					Reason: "This file is sensitive and is readable to all users",
 					Detail: "UnSafe privilege " + priv,
+					Layer:  layerOf(fsys, task),
 				})
 			}
 		}
 	}
 }
 
-func checkEmptyPasswdRoot(dirPath string, escaperiskDetails *[]*EscapeRiskDetail) {
+func checkEmptyPasswdRoot(fsys fs.FS, escaperiskDetails *[]*EscapeRiskDetail) {
 	privilegedUser := make(map[string]struct{})
 
-	filePasswd, err := os.Open(filepath.Join(dirPath, "/etc/passwd"))
+	filePasswd, err := fsys.Open("etc/passwd")
 	if err != nil {
 		return
 	}
@@ -188,7 +229,7 @@ func checkEmptyPasswdRoot(dirPath string, escaperiskDetails *[]*EscapeRiskDetail
 		return
 	}
 
-	fileShadow, err := os.Open(filepath.Join(dirPath, "/etc/shadow"))
+	fileShadow, err := fsys.Open("etc/shadow")
 	if err != nil {
 		return
 	}
@@ -203,6 +244,7 @@ func checkEmptyPasswdRoot(dirPath string, escaperiskDetails *[]*EscapeRiskDetail
 					Target: "/etc/shadow",
 					Reason: "This user is privileged but does not have a password set",
 					Detail: "UnsafeUser " + attr[0],
+					Layer:  layerOf(fsys, "/etc/shadow"),
 				})
 			}
 		}
@@ -5,6 +5,8 @@ import (
 	"imgscan/cmd/imgscan/image/analyze"
 	"imgscan/cmd/imgscan/image/backdoor"
 	"imgscan/cmd/imgscan/image/escaperisk"
+	"imgscan/cmd/imgscan/image/layersecrets"
+	"imgscan/cmd/imgscan/image/secrets"
 	"imgscan/internal/logger"
 )
 
@@ -31,6 +33,8 @@ func (m imageCommand) build() *cli.Command {
 		analyze.NewCommand(m.logger),
 		backdoor.NewCommand(m.logger),
 		escaperisk.NewCommand(m.logger),
+		layersecrets.NewCommand(m.logger),
+		secrets.NewCommand(m.logger),
 	}
 
 	return &image
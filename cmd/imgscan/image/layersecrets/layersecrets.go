@@ -0,0 +1,63 @@
+package layersecrets
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/cmd/imgscan/flags"
+	"imgscan/internal/logger"
+)
+
+type layersecretsCommand struct {
+	logger logger.Interface
+}
+
+type options struct {
+	archive   string
+	ociLayout string
+	platform  string
+	// showSecrets shows the secrets found in full instead of redacted
+	showSecrets bool
+	entropy     flags.EntropyOptions
+}
+
+// NewCommand constructs a layersecrets-command with the specified logger
+func NewCommand(logger logger.Interface) *cli.Command {
+	c := layersecretsCommand{
+		logger: logger,
+	}
+	return c.build()
+}
+
+func (m layersecretsCommand) build() *cli.Command {
+	opts := options{}
+	return &cli.Command{
+		Name:  "layersecrets",
+		Usage: "Scan the intermediate layers of the specified image for deleted or overwritten credentials",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "Scan an image archive created by docker save instead of a local image",
+				Aliases:     []string{"a"},
+				Destination: &opts.archive,
+			},
+			&cli.StringFlag{
+				Name:        "oci-layout",
+				Usage:       "Scan an image stored in an OCI image layout directory instead of a local image",
+				Destination: &opts.ociLayout,
+			},
+			&cli.StringFlag{
+				Name:        "platform",
+				Usage:       "Select the os/arch[/variant] image of a multi-arch OCI layout, required when it holds several images",
+				Aliases:     []string{"p"},
+				Destination: &opts.platform,
+			},
+			&cli.BoolFlag{
+				Name:        "show-secrets",
+				Usage:       "Show the secrets found in full, instead of redacted",
+				Destination: &opts.showSecrets,
+			},
+		}, opts.entropy.Flags("Also search the other hidden text files for random strings such as tokens and keys, by their entropy")...),
+		Action: func(c *cli.Context) error {
+			return m.scanLayerSecrets(c, &opts)
+		},
+	}
+}
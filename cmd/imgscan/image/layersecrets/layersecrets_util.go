@@ -0,0 +1,181 @@
+package layersecrets
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"imgscan/internal/docker"
+	"imgscan/internal/secrets"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+const (
+	STATUS_DELETED     = "deleted in a later layer"
+	STATUS_OVERWRITTEN = "overwritten in a later layer"
+)
+
+type LayerSecretDetail struct {
+	FilePath string
+	// Line is the line of the secret found by the entropy detector, 0 for a credential file
+	Line int
+	// ID identifies the kind of credential file, or the entropy rule
+	ID          string
+	Description string
+	// Match is the secret found by the entropy detector
+	Match       string
+	Fingerprint string
+	Status      string
+	// Layer is the layer whose tarball still holds the credential
+	Layer *docker.Layer
+}
+
+func (m layersecretsCommand) scanLayerSecrets(c *cli.Context, opts *options) error {
+	if opts.archive == "" && opts.ociLayout == "" && c.Args().Len() != 1 {
+		err := fmt.Errorf("an image name, --archive or --oci-layout is needed")
+		m.logger.Errorf("please check the parameters: %v", err)
+		return err
+	}
+
+	imageFS, err := docker.OpenImage(docker.Source{
+		Image:     c.Args().First(),
+		Archive:   opts.archive,
+		OCILayout: opts.ociLayout,
+		Platform:  opts.platform,
+		Keep: func(name string, size int64, head []byte) bool {
+			return size <= secrets.MaxCredentialFileSize && secrets.MayHoldSecrets(name, head)
+		},
+	})
+	if err != nil {
+		m.logger.Errorf("err opening image layers: %v", err)
+		return err
+	}
+	defer imageFS.Close()
+
+	results, err := layerSecretsCheck(imageFS, opts.entropy.Detector())
+	if err != nil {
+		m.logger.Errorf("err scan image layers: %v", err)
+		return err
+	}
+	if len(results) == 0 {
+		m.logger.Infof("no hidden credentials found")
+	} else {
+		if !opts.showSecrets {
+			for _, result := range results {
+				if result.Match != "" {
+					result.Match = secrets.Redact(result.Match)
+				}
+			}
+		}
+		printResults(results)
+	}
+
+	return nil
+}
+
+// layerSecretsCheck walks every layer on its own and reports the credential files the
+// final filesystem no longer shows, their content is still shipped in the layer tarball.
+// The other text files hidden this way are searched for secrets when detector is set
+func layerSecretsCheck(imageFS *docker.ImageFS, detector *secrets.EntropyDetector) ([]*LayerSecretDetail, error) {
+	var layerSecretDetails []*LayerSecretDetail
+
+	for _, layer := range imageFS.Layers() {
+		err := layer.Walk(func(name string, header *tar.Header, content io.Reader) error {
+			if header.Size <= 0 || header.Size > secrets.MaxCredentialFileSize {
+				return nil
+			}
+			contents, err := io.ReadAll(content)
+			if err != nil {
+				return err
+			}
+
+			credential := secrets.MatchCredentialFile(name, contents)
+			if credential == nil && (detector == nil || secrets.IsBinary(contents)) {
+				return nil
+			}
+			status, hidden := finalStatus(imageFS, layer, name, contents)
+			if !hidden {
+				return nil
+			}
+
+			if credential != nil {
+				layerSecretDetails = append(layerSecretDetails, &LayerSecretDetail{
+					FilePath:    "/" + name,
+					ID:          credential.ID,
+					Description: credential.Description,
+					Fingerprint: secrets.Fingerprint(credential.ID, "/"+name, ""),
+					Status:      status,
+					Layer:       layer,
+				})
+				return nil
+			}
+			for _, secret := range detector.Find(string(contents)) {
+				layerSecretDetails = append(layerSecretDetails, &LayerSecretDetail{
+					FilePath:    "/" + name,
+					Line:        secret.Line,
+					ID:          secret.Kind.ID,
+					Description: secret.Kind.Description,
+					Match:       secret.Value,
+					Fingerprint: secrets.Fingerprint(secret.Kind.ID, "/"+name, strconv.Itoa(secret.Line)),
+					Status:      status,
+					Layer:       layer,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return layerSecretDetails, nil
+}
+
+// finalStatus reports whether a file written by layer is missing from the final
+// filesystem, or replaced there by different content
+func finalStatus(imageFS *docker.ImageFS, layer *docker.Layer, name string, contents []byte) (string, bool) {
+	info, err := imageFS.Lstat(name)
+	if err != nil {
+		return STATUS_DELETED, true
+	}
+	if !info.Mode().IsRegular() {
+		return STATUS_OVERWRITTEN, true
+	}
+	if docker.LayerOf(info) == layer {
+		return "", false
+	}
+
+	final, err := fs.ReadFile(imageFS, name)
+	if err != nil || !bytes.Equal(final, contents) {
+		return STATUS_OVERWRITTEN, true
+	}
+	return "", false
+}
+
+func printResults(results []*LayerSecretDetail) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"File Path", "Id", "Description", "Match", "Status", "Layer", "Created By", "Fingerprint"})
+
+	for _, result := range results {
+		location := result.FilePath
+		if result.Line > 0 {
+			location = fmt.Sprintf("%s:%d", result.FilePath, result.Line)
+		}
+		table.Append([]string{
+			location,
+			result.ID,
+			result.Description,
+			result.Match,
+			result.Status,
+			result.Layer.String(),
+			result.Layer.Instruction(),
+			result.Fingerprint,
+		})
+	}
+	table.SetBorder(true)
+	table.Render()
+}
@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/cmd/imgscan/flags"
+	"imgscan/internal/logger"
+	detect "imgscan/internal/secrets"
+)
+
+type secretsCommand struct {
+	logger logger.Interface
+}
+
+type options struct {
+	archive            string
+	ociLayout          string
+	platform           string
+	ignoreRule         cli.StringSlice
+	customizedRuleFile cli.StringSlice
+	rulesDir           string
+	outputFile         string
+	maxFileSize        int64
+	showSecrets        bool
+	entropy            flags.EntropyOptions
+}
+
+// NewCommand constructs a secrets-command with the specified logger
+func NewCommand(logger logger.Interface) *cli.Command {
+	c := secretsCommand{
+		logger: logger,
+	}
+	return c.build()
+}
+
+func (m secretsCommand) build() *cli.Command {
+	opts := options{}
+	return &cli.Command{
+		Name:      "secrets",
+		Usage:     "Scan the files of the specified image for credentials, tokens and keys",
+		ArgsUsage: "<image>",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "Scan an image archive created by docker save instead of a local image",
+				Aliases:     []string{"a"},
+				Destination: &opts.archive,
+			},
+			&cli.StringFlag{
+				Name:        "oci-layout",
+				Usage:       "Scan an image stored in an OCI image layout directory instead of a local image",
+				Destination: &opts.ociLayout,
+			},
+			&cli.StringFlag{
+				Name:        "platform",
+				Usage:       "Select the os/arch[/variant] image of a multi-arch OCI layout, required when it holds several images",
+				Aliases:     []string{"p"},
+				Destination: &opts.platform,
+			},
+			&cli.StringSliceFlag{
+				Name:        "ignore-rule",
+				Usage:       "Ignore specific IDs of the credential rules, the entropy detector or the credential files",
+				Aliases:     []string{"i"},
+				Destination: &opts.ignoreRule,
+			},
+			&cli.StringSliceFlag{
+				Name:        "customized-rules-file",
+				Usage:       "Also apply the rules of a user defined rules file (remote url or file) that match file contents",
+				Aliases:     []string{"c"},
+				Destination: &opts.customizedRuleFile,
+			},
+			&cli.StringFlag{
+				Name:        "rules-dir",
+				Usage:       "Read the credentials rule pack from this directory, then from IMGSCAN_RULES_PATH, then from the one built into imgscan",
+				Destination: &opts.rulesDir,
+			},
+			&cli.StringFlag{
+				Name:        "output-file",
+				Usage:       "Export the secrets found as a json",
+				Aliases:     []string{"o"},
+				Destination: &opts.outputFile,
+			},
+			&cli.Int64Flag{
+				Name:        "max-file-size",
+				Usage:       "Size in bytes above which files are skipped",
+				Value:       detect.MaxCredentialFileSize,
+				Destination: &opts.maxFileSize,
+			},
+			&cli.BoolFlag{
+				Name:        "show-secrets",
+				Usage:       "Show the secrets found in full, instead of redacted",
+				Destination: &opts.showSecrets,
+			},
+		}, opts.entropy.Flags("Also search text files for random strings such as tokens and keys, by their entropy")...),
+		Action: func(c *cli.Context) error {
+			return m.scanSecrets(c, &opts)
+		},
+	}
+}
@@ -0,0 +1,300 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"imgscan/internal/docker"
+	detect "imgscan/internal/secrets"
+	"imgscan/rules"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CREDENTIAL_FILE_SEVERITY is the severity of a known credential file, its whole content is the secret
+const CREDENTIAL_FILE_SEVERITY = "High"
+
+// SecretDetail is a secret found in a file of the image
+type SecretDetail struct {
+	FilePath string `json:"path"`
+	// Line is the line the secret starts on, 0 for a credential file recognized by its format
+	Line int `json:"line,omitempty"`
+	// ID is the rule ID, the entropy rule ID or the kind of credential file
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	// Match is the secret, empty for a credential file
+	Match string `json:"match,omitempty"`
+	// Confidence is set for the rules setting verify
+	Confidence  string `json:"confidence,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	// Layer is the layer that last wrote the file
+	Layer     *docker.Layer `json:"-"`
+	LayerID   string        `json:"layer"`
+	CreatedBy string        `json:"created_by"`
+}
+
+// Report is the JSON output of the secrets command
+type Report struct {
+	Secrets []*SecretDetail `json:"secrets"`
+	// FileCount is the number of text files scanned, SkippedCount the number of files
+	// larger than the size limit
+	FileCount    int `json:"file_count"`
+	SkippedCount int `json:"skipped_count"`
+}
+
+// Location returns the path:line of the secret, or the path of a credential file
+func (d *SecretDetail) Location() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d", d.FilePath, d.Line)
+	}
+	return d.FilePath
+}
+
+// scanner holds what every file of the image is scanned with
+type scanner struct {
+	compiled []*rules.Compiled
+	// detector finds random strings, nil when entropy detection is turned off
+	detector    *detect.EntropyDetector
+	ignoreIDs   map[string]bool
+	maxFileSize int64
+}
+
+func (m secretsCommand) scanSecrets(c *cli.Context, opts *options) error {
+	if opts.archive == "" && opts.ociLayout == "" && c.Args().Len() != 1 {
+		err := fmt.Errorf("an image name, --archive or --oci-layout is needed")
+		m.logger.Errorf("please check the parameters: %v", err)
+		return err
+	}
+
+	ruleSet, err := rules.Load("credentials", opts.rulesDir, opts.customizedRuleFile.Value())
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+
+	s := scanner{
+		ignoreIDs:   make(map[string]bool),
+		maxFileSize: opts.maxFileSize,
+	}
+	for _, id := range opts.ignoreRule.Value() {
+		s.ignoreIDs[id] = true
+	}
+	ruleSet, err = rules.Usable(ruleSet, s.ignoreIDs)
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+	for _, rule := range ruleSet {
+		compiled, err := rules.Compile(rule)
+		if err != nil {
+			m.logger.Errorf("%v", err)
+			return err
+		}
+		if compiled.AppliesToFiles() {
+			s.compiled = append(s.compiled, compiled)
+		}
+	}
+	s.detector = opts.entropy.Detector()
+
+	imageFS, err := docker.OpenImage(docker.Source{
+		Image:     c.Args().First(),
+		Archive:   opts.archive,
+		OCILayout: opts.ociLayout,
+		Platform:  opts.platform,
+		Keep: func(name string, size int64, head []byte) bool {
+			return size <= s.maxFileSize && detect.MayHoldSecrets(name, head)
+		},
+	})
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return fmt.Errorf("failed to open image layers: %w", err)
+	}
+	defer imageFS.Close()
+
+	report, err := secretsCheck(imageFS, s)
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return fmt.Errorf("failed to scan image files: %w", err)
+	}
+
+	if !opts.showSecrets {
+		for _, detail := range report.Secrets {
+			if detail.Match != "" {
+				detail.Match = detect.Redact(detail.Match)
+			}
+		}
+	}
+	if len(report.Secrets) == 0 {
+		m.logger.Infof("No secrets found")
+	} else {
+		printResults(report.Secrets)
+	}
+	m.logger.Infof("%d files scanned, %d skipped above %d bytes, %d secrets found",
+		report.FileCount, report.SkippedCount, s.maxFileSize, len(report.Secrets))
+
+	if outputFile := opts.outputFile; outputFile != "" {
+		file, err := os.Create(outputFile)
+		if err != nil {
+			m.logger.Errorf("%v", err)
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+
+		if err := json.NewEncoder(file).Encode(report); err != nil {
+			m.logger.Errorf("%v", err)
+			return fmt.Errorf("failed to write secrets to output file: %w", err)
+		}
+	}
+	return nil
+}
+
+// secretsCheck walks the flattened filesystem of the image and scans every regular file
+// within the size limit, symlinks are not followed so that each file is scanned once
+func secretsCheck(imageFS *docker.ImageFS, s scanner) (*Report, error) {
+	report := &Report{Secrets: []*SecretDetail{}}
+	err := fs.WalkDir(imageFS, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			return nil
+		}
+		if info.Size() > s.maxFileSize {
+			report.SkippedCount++
+			return nil
+		}
+
+		content, err := imageFS.ReadFile(name)
+		if errors.Is(err, docker.ErrNotKept) {
+			// A binary that is no credential file is not kept from the stream of a local image
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found := s.scanFile("/"+name, content)
+		for _, detail := range found {
+			detail.Layer = docker.LayerOf(info)
+			detail.LayerID = detail.Layer.String()
+			detail.CreatedBy = detail.Layer.Instruction()
+		}
+		report.Secrets = append(report.Secrets, found...)
+		if !detect.IsBinary(content) {
+			report.FileCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// scanFile recognizes a known credential file by its path and format, then applies the
+// rules and the entropy detector to its text. Binaries are only checked as credential
+// files, such as a PKCS#12 keystore
+func (s scanner) scanFile(name string, content []byte) []*SecretDetail {
+	var found []*SecretDetail
+	if credential := detect.MatchCredentialFile(name, content); credential != nil && !s.ignoreIDs[credential.ID] {
+		detail := &SecretDetail{
+			FilePath:    name,
+			ID:          credential.ID,
+			Description: credential.Description,
+			Severity:    CREDENTIAL_FILE_SEVERITY,
+			Fingerprint: detect.Fingerprint(credential.ID, name, ""),
+		}
+		if credential.Content != nil {
+			if loc := credential.Content.FindIndex(content); loc != nil {
+				detail.Line = bytes.Count(content[:loc[0]], []byte("\n")) + 1
+			}
+		}
+		found = append(found, detail)
+	}
+	if detect.IsBinary(content) {
+		return found
+	}
+
+	text := string(content)
+	var matched []string
+	for _, rule := range s.compiled {
+		for _, match := range rule.MatchFile(text) {
+			matched = append(matched, match.Secret)
+			found = append(found, &SecretDetail{
+				FilePath:    name,
+				Line:        match.Line,
+				ID:          match.Rule.ID,
+				Description: match.Rule.Description,
+				Severity:    match.Rule.Severity,
+				Match:       match.Secret,
+				Confidence:  match.Confidence,
+				Fingerprint: detect.Fingerprint(match.Rule.ID, name, strconv.Itoa(match.Line)),
+			})
+		}
+	}
+	if s.detector != nil {
+		for _, secret := range s.detector.Find(text) {
+			if s.ignoreIDs[secret.Kind.ID] || containedIn(matched, secret.Value) {
+				continue
+			}
+			found = append(found, &SecretDetail{
+				FilePath:    name,
+				Line:        secret.Line,
+				ID:          secret.Kind.ID,
+				Description: secret.Kind.Description,
+				Severity:    secret.Kind.Severity,
+				Match:       secret.Value,
+				Fingerprint: detect.Fingerprint(secret.Kind.ID, name, strconv.Itoa(secret.Line)),
+			})
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Line < found[j].Line
+	})
+	return found
+}
+
+// containedIn reports whether a secret of the entropy detector is part of a secret a rule
+// already reported, the rule tells more about it
+func containedIn(matched []string, value string) bool {
+	for _, secret := range matched {
+		if strings.Contains(secret, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func printResults(results []*SecretDetail) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"File Path", "Id", "Description", "Severity", "Match", "Confidence", "Layer", "Created By"})
+
+	for _, result := range results {
+		table.Append([]string{
+			result.Location(),
+			result.ID,
+			result.Description,
+			result.Severity,
+			result.Match,
+			result.Confidence,
+			result.LayerID,
+			result.CreatedBy,
+		})
+	}
+	table.SetBorder(true)
+	table.Render()
+}
@@ -5,6 +5,7 @@ import (
 	cli "github.com/urfave/cli/v2"
 	"imgscan/cmd/imgscan/dockerfile"
 	"imgscan/cmd/imgscan/image"
+	"imgscan/cmd/imgscan/rules"
 	"imgscan/internal/info"
 	"os"
 )
@@ -65,6 +66,7 @@ func main() {
 	c.Commands = []*cli.Command{
 		image.NewCommand(logger),
 		dockerfile.NewCommand(logger),
+		rules.NewCommand(logger),
 	}
 
 	// Run the CLI
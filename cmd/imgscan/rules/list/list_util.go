@@ -0,0 +1,33 @@
+package list
+
+import (
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"imgscan/rules"
+	"os"
+)
+
+func (m listCommand) listRules(c *cli.Context, opts *options) error {
+	ruleSet, err := rules.Load(opts.mode, opts.rulesDir, opts.customizedRuleFile.Value())
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+
+	if len(ruleSet) == 0 {
+		m.logger.Infof("No rules loaded")
+		return nil
+	}
+
+	data := make([][]string, len(ruleSet))
+	for i, rule := range ruleSet {
+		data[i] = []string{rule.ID, rule.Severity, rule.Description, rule.Source}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Rule Id", "Severity", "Description", "Source"})
+	table.SetBorder(true)
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,37 @@
+package rules
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/cmd/imgscan/rules/list"
+	"imgscan/cmd/imgscan/rules/show"
+	"imgscan/cmd/imgscan/rules/test"
+	"imgscan/internal/logger"
+)
+
+type rulesCommand struct {
+	logger logger.Interface
+}
+
+// NewCommand constructs a rules command with the specified logger
+func NewCommand(logger logger.Interface) *cli.Command {
+	c := rulesCommand{
+		logger: logger,
+	}
+	return c.build()
+}
+
+func (m rulesCommand) build() *cli.Command {
+	// Create the 'rules' command
+	rules := cli.Command{
+		Name:  "rules",
+		Usage: "Inspect the rules the dockerfile command applies",
+	}
+
+	rules.Subcommands = []*cli.Command{
+		list.NewCommand(m.logger),
+		show.NewCommand(m.logger),
+		test.NewCommand(m.logger),
+	}
+
+	return &rules
+}
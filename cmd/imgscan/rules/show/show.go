@@ -0,0 +1,55 @@
+package show
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/internal/logger"
+)
+
+type showCommand struct {
+	logger logger.Interface
+}
+
+type options struct {
+	customizedRuleFile cli.StringSlice
+	mode               string
+	rulesDir           string
+}
+
+// NewCommand constructs a show command with the specified logger
+func NewCommand(logger logger.Interface) *cli.Command {
+	c := showCommand{
+		logger: logger,
+	}
+	return c.build()
+}
+
+func (m showCommand) build() *cli.Command {
+	opts := options{}
+	return &cli.Command{
+		Name:  "show",
+		Usage: "Show the rules with the specified ID and the file they come from",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:        "customized-rules-file",
+				Usage:       "Using user defined rules file (remote url or file)",
+				Aliases:     []string{"c"},
+				Destination: &opts.customizedRuleFile,
+			},
+			&cli.StringFlag{
+				Name:        "mode",
+				Usage:       "Using default rules [core, credentials, shell, all (default value), none], or any other pack of the rules directories by its name",
+				Aliases:     []string{"m"},
+				Value:       "all",
+				Destination: &opts.mode,
+			},
+			&cli.StringFlag{
+				Name:        "rules-dir",
+				Usage:       "Read the default rule packs from this directory, packs it does not hold are looked up in IMGSCAN_RULES_PATH, then in the ones built into imgscan",
+				Destination: &opts.rulesDir,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return m.showRule(c, &opts)
+		},
+	}
+}
@@ -0,0 +1,44 @@
+package show
+
+import (
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+	"imgscan/rules"
+)
+
+func (m showCommand) showRule(c *cli.Context, opts *options) error {
+	if c.Args().Len() == 0 {
+		err := fmt.Errorf("rule id is needed")
+		m.logger.Errorf("%v", err)
+		return err
+	}
+	id := c.Args().First()
+
+	ruleSet, err := rules.Load(opts.mode, opts.rulesDir, opts.customizedRuleFile.Value())
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+
+	// Custom rules may reuse the ID of a default one, every rule with the ID is shown
+	found := false
+	for _, rule := range ruleSet {
+		if rule.ID != id {
+			continue
+		}
+		content, err := yaml.Marshal([]rules.Rule{rule})
+		if err != nil {
+			return fmt.Errorf("failed to marshal rule %s: %w", id, err)
+		}
+		fmt.Printf("# source: %s\n%s", rule.Source, content)
+		found = true
+	}
+
+	if !found {
+		err := fmt.Errorf("rule %s not found", id)
+		m.logger.Errorf("%v", err)
+		return err
+	}
+	return nil
+}
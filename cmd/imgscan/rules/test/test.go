@@ -0,0 +1,55 @@
+package test
+
+import (
+	"github.com/urfave/cli/v2"
+	"imgscan/internal/logger"
+)
+
+type testCommand struct {
+	logger logger.Interface
+}
+
+type options struct {
+	customizedRuleFile cli.StringSlice
+	mode               string
+	rulesDir           string
+}
+
+// NewCommand constructs a test command with the specified logger
+func NewCommand(logger logger.Interface) *cli.Command {
+	c := testCommand{
+		logger: logger,
+	}
+	return c.build()
+}
+
+func (m testCommand) build() *cli.Command {
+	opts := options{}
+	return &cli.Command{
+		Name:  "test",
+		Usage: "Validate the rules and run the match and no_match examples of each one",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:        "customized-rules-file",
+				Usage:       "Using user defined rules file (remote url or file)",
+				Aliases:     []string{"c"},
+				Destination: &opts.customizedRuleFile,
+			},
+			&cli.StringFlag{
+				Name:        "mode",
+				Usage:       "Using default rules [core, credentials, shell, all (default value), none], or any other pack of the rules directories by its name",
+				Aliases:     []string{"m"},
+				Value:       "all",
+				Destination: &opts.mode,
+			},
+			&cli.StringFlag{
+				Name:        "rules-dir",
+				Usage:       "Read the default rule packs from this directory, packs it does not hold are looked up in IMGSCAN_RULES_PATH, then in the ones built into imgscan",
+				Destination: &opts.rulesDir,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return m.testRules(c, &opts)
+		},
+	}
+}
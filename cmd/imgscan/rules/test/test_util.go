@@ -0,0 +1,64 @@
+package test
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"imgscan/rules"
+	"os"
+	"strings"
+)
+
+const (
+	KIND_VALIDATION = "validation"
+	KIND_MATCH      = "match"
+	KIND_NO_MATCH   = "no_match"
+)
+
+func (m testCommand) testRules(c *cli.Context, opts *options) error {
+	ruleSet, err := rules.Load(opts.mode, opts.rulesDir, opts.customizedRuleFile.Value())
+	if err != nil {
+		m.logger.Errorf("%v", err)
+		return err
+	}
+
+	var data [][]string
+	for _, problem := range rules.Validate(ruleSet) {
+		data = append(data, []string{problem.ID, problem.Source, KIND_VALIDATION, "", problem.Message})
+	}
+
+	examples := 0
+	for _, rule := range ruleSet {
+		results, err := rules.RunExamples(rule)
+		if err != nil {
+			// Already reported by the validation
+			continue
+		}
+		examples += len(results)
+		for _, result := range results {
+			if result.Passed() {
+				continue
+			}
+			kind, message := KIND_MATCH, "rule did not match"
+			if !result.WantMatch {
+				kind, message = KIND_NO_MATCH, "rule matched"
+			}
+			if result.Err != nil {
+				message = fmt.Sprintf("invalid Dockerfile: %v", result.Err)
+			}
+			data = append(data, []string{rule.ID, rule.Source, kind, strings.TrimSpace(result.Example), message})
+		}
+	}
+
+	if len(data) == 0 {
+		m.logger.Infof("%d rules valid, %d examples passed", len(ruleSet), examples)
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Rule Id", "Source", "Check", "Example", "Problem"})
+	table.SetBorder(true)
+	table.AppendBulk(data)
+	table.Render()
+	return fmt.Errorf("%d of the checks of %d rules failed", len(data), len(ruleSet))
+}
@@ -2,76 +2,180 @@ package docker
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 )
 
-func extractImage(imageName, workDir string) error {
-	tarFile := fmt.Sprintf("%s.tar", imageName)
-	cmd := exec.Command("docker", "save", "-o", tarFile, imageName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("export image failed: %v", err)
-	}
-	defer os.Remove(tarFile)
+// maxBufferedEntrySize bounds the entries of a streamed image archive that are held in
+// memory besides its layers, manifest.json and the image config are far smaller
+const maxBufferedEntrySize = 16 << 20
 
-	tarReader, err := os.Open(tarFile)
-	if err != nil {
-		return fmt.Errorf("open image failed: %v", err)
-	}
-	defer tarReader.Close()
+// archiveEntry is an entry of an image archive
+type archiveEntry struct {
+	typeflag byte
+	// content holds the content of a regular file, read in place or buffered from a stream
+	content *io.SectionReader
+	// layer holds a layer tarball indexed from a stream
+	layer    *streamedLayer
+	linkname string
+}
+
+// imageArchive is an image archive in the `docker save` format
+type imageArchive struct {
+	entries map[string]archiveEntry
+}
 
-	tarBall := tar.NewReader(tarReader)
+// indexArchive indexes an image archive in place, entries escaping the archive root are
+// refused and reported on imageFS if set
+func indexArchive(section *io.SectionReader, archiveName string, imageFS *ImageFS) (*imageArchive, error) {
+	archive := &imageArchive{
+		entries: make(map[string]archiveEntry),
+	}
+	// The section reader lets tar.Reader skip layer contents with seeks
+	tarBall := tar.NewReader(section)
 	for {
 		header, err := tarBall.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("open image tar failed: %v", err)
+			return nil, fmt.Errorf("open image tar failed: %v", err)
 		}
 
-		targetPath := filepath.Join(workDir, header.Name)
-		switch header.Typeflag {
-		case tar.TypeDir:
-			err = os.MkdirAll(targetPath, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("create dir failed: %v", err)
+		name, ok := safeName(header.Name)
+		if !ok {
+			if imageFS != nil {
+				imageFS.addUnsafeArchiveEntry(archiveName, header)
 			}
-		case tar.TypeReg:
-			file, err := os.Create(targetPath)
+			continue
+		}
+
+		entry := archiveEntry{
+			typeflag: header.Typeflag,
+			linkname: header.Linkname,
+		}
+		if header.Typeflag == tar.TypeReg {
+			offset, err := section.Seek(0, io.SeekCurrent)
 			if err != nil {
-				return fmt.Errorf("create file failed: %v", err)
+				return nil, fmt.Errorf("open image tar failed: %v", err)
 			}
-			defer file.Close()
+			entry.content = io.NewSectionReader(section, offset, header.Size)
+		}
+		archive.entries[name] = entry
+	}
 
-			_, err = io.Copy(file, tarBall)
-			if err != nil {
-				return fmt.Errorf("write file failed: %v", err)
+	return archive, nil
+}
+
+// indexStream indexes an image archive read from a stream in a single pass, nothing is
+// written on disk. The layer tarballs are indexed as they come, gzip-compressed ones are
+// decompressed on the fly, and only the content of the files keep selects is held in
+// memory. The other entries are buffered, they hold manifest.json and the image config
+func indexStream(reader io.Reader, archiveName string, imageFS *ImageFS, keep KeepFunc) (*imageArchive, error) {
+	archive := &imageArchive{
+		entries: make(map[string]archiveEntry),
+	}
+	tarBall := tar.NewReader(reader)
+	for {
+		header, err := tarBall.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read image tar failed: %v", err)
+		}
+
+		name, ok := safeName(header.Name)
+		if !ok {
+			imageFS.addUnsafeArchiveEntry(archiveName, header)
+			continue
+		}
+
+		entry := archiveEntry{
+			typeflag: header.Typeflag,
+			linkname: header.Linkname,
+		}
+		if header.Typeflag == tar.TypeReg {
+			// Layers are told apart by their content, `docker save` names every blob by its digest
+			content := bufio.NewReader(tarBall)
+			head, _ := content.Peek(512)
+			switch {
+			case isGzip(head) || isTarball(head):
+				entry.layer = indexStreamedLayer(content, head, keep)
+			case header.Size <= maxBufferedEntrySize:
+				data, err := io.ReadAll(content)
+				if err != nil {
+					return nil, fmt.Errorf("read image tar failed: %v", err)
+				}
+				entry.content = io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
 			}
 		}
+		archive.entries[name] = entry
 	}
 
-	return nil
+	return archive, nil
 }
 
-func getLayersFromManifest(workDir string) ([]string, error) {
-	manifestPath := filepath.Join(workDir, "manifest.json")
-	file, err := os.Open(manifestPath)
+// resolve returns the regular file entry name leads to, following the symlinks and
+// hardlinks older `docker save` versions use to share layers between images
+func (a *imageArchive) resolve(name string) (archiveEntry, error) {
+	for hops := 0; hops <= maxSymlinkHops; hops++ {
+		entry, ok := a.entries[name]
+		if !ok {
+			return archiveEntry{}, fmt.Errorf("%s not found in the image archive", name)
+		}
+
+		var target string
+		switch entry.typeflag {
+		case tar.TypeReg:
+			return entry, nil
+		case tar.TypeSymlink:
+			target = path.Join(path.Dir(name), entry.linkname)
+		case tar.TypeLink:
+			target = entry.linkname
+		default:
+			return archiveEntry{}, fmt.Errorf("%s is not a file in the image archive", name)
+		}
+
+		name, ok = safeName(target)
+		if !ok {
+			return archiveEntry{}, fmt.Errorf("%s escapes the image archive", target)
+		}
+	}
+	return archiveEntry{}, fmt.Errorf("too many levels of links in the image archive")
+}
+
+// open returns the content of an archive entry
+func (a *imageArchive) open(name string) (*io.SectionReader, error) {
+	entry, err := a.resolve(name)
 	if err != nil {
-		return nil, fmt.Errorf("open manifest.json failed: %v", err)
+		return nil, err
 	}
-	defer file.Close()
+	if entry.content == nil {
+		return nil, fmt.Errorf("%s was not buffered from the image stream", name)
+	}
+	return io.NewSectionReader(entry.content, 0, entry.content.Size()), nil
+}
 
-	var manifests []struct {
-		Layers []string `json:"Layers"`
+// archiveManifest is the entry of manifest.json that describes the image of an archive
+type archiveManifest struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+func readArchiveManifest(archive *imageArchive) (*archiveManifest, error) {
+	file, err := archive.open("manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("open manifest.json failed: %v", err)
 	}
+
+	var manifests []archiveManifest
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&manifests)
 	if err != nil {
@@ -79,88 +183,238 @@ func getLayersFromManifest(workDir string) ([]string, error) {
 	}
 
 	if len(manifests) == 0 {
-		return nil, fmt.Errorf("no layers in manifest.json")
+		return nil, fmt.Errorf("no image in manifest.json")
 	}
-
-	return manifests[0].Layers, nil
+	return &manifests[0], nil
 }
 
-func extractLayerToDir(layerFile, targetDir string) error {
-	layerTar, err := os.Open(layerFile)
+func getLayersFromManifest(archive *imageArchive) ([]*Layer, error) {
+	manifest, err := readArchiveManifest(archive)
 	if err != nil {
-		return fmt.Errorf("open layer file failed %s: %v", layerFile, err)
+		return nil, err
 	}
-	defer layerTar.Close()
 
-	tarReader := tar.NewReader(layerTar)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	var layers []*Layer
+	for i, layer := range manifest.Layers {
+		name, ok := safeName(layer)
+		if !ok {
+			return nil, fmt.Errorf("layer %s escapes the image archive", layer)
 		}
+		entry, err := archive.resolve(name)
 		if err != nil {
-			return fmt.Errorf("read layer file failed: %v", err)
+			return nil, fmt.Errorf("open layer %s failed: %v", layer, err)
 		}
 
-		targetPath := filepath.Join(targetDir, header.Name)
-		if header.Typeflag == tar.TypeDir {
-			err := os.MkdirAll(targetPath, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("create dir failed %s: %v", targetPath, err)
-			}
-		} else if header.Typeflag == tar.TypeReg {
-			err := os.MkdirAll(filepath.Dir(targetPath), os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("create dir failed %s: %v", filepath.Dir(targetPath), err)
-			}
-
-			outFile, err := os.Create(targetPath)
-			if err != nil {
-				return fmt.Errorf("create file failed %s: %v", targetPath, err)
-			}
-			defer outFile.Close()
+		indexed := &Layer{
+			Name:  name,
+			Index: i,
+		}
+		switch {
+		case entry.layer != nil && entry.layer.err != nil:
+			return nil, fmt.Errorf("read layer %s failed: %v", layer, entry.layer.err)
+		case entry.layer != nil:
+			indexed.streamed, indexed.entries = true, entry.layer.entries
+		case entry.content != nil:
+			indexed.blob, indexed.size = entry.content, entry.content.Size()
+		default:
+			return nil, fmt.Errorf("layer %s is not a tarball", layer)
+		}
+		layers = append(layers, indexed)
+	}
 
-			_, err = io.Copy(outFile, tarReader)
-			if err != nil {
-				return fmt.Errorf("write file failed %s: %v", targetPath, err)
-			}
+	// The image config only describes the layers, an archive without a readable one is still scanned
+	content, err := readArchiveConfig(archive, manifest)
+	if err == nil {
+		var config imageConfig
+		if json.Unmarshal(content, &config) == nil {
+			describeLayers(layers, &config)
 		}
 	}
-	return nil
+	return layers, nil
 }
 
-func mountLayers(layers []string, workDir string) error {
-	for _, layer := range layers {
-		layerFile := filepath.Join(workDir, layer)
-		err := extractLayerToDir(layerFile, workDir)
-		if err != nil {
-			return fmt.Errorf("extract layer %s failed: %v", layer, err)
-		}
+func readArchiveConfig(archive *imageArchive, manifest *archiveManifest) ([]byte, error) {
+	name, ok := safeName(manifest.Config)
+	if !ok {
+		return nil, fmt.Errorf("config %s escapes the image archive", manifest.Config)
+	}
+	file, err := archive.open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open image config failed: %v", err)
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read image config failed: %v", err)
+	}
+	return content, nil
+}
+
+// ReadArchiveConfig returns the raw image config of an image archive created by `docker save`
+func ReadArchiveConfig(archivePath string) ([]byte, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("open image failed: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat image archive failed: %v", err)
+	}
+	archive, err := indexArchive(io.NewSectionReader(file, 0, info.Size()), filepath.Base(archivePath), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := readArchiveManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+	return readArchiveConfig(archive, manifest)
+}
+
+// Source describes where the image to scan comes from, Archive and OCILayout
+// take precedence over a local image
+type Source struct {
+	// Image is the name or ID of an image known to the local Docker daemon
+	Image string
+	// Archive is the path of an image archive created by `docker save`
+	Archive string
+	// OCILayout is the path of an OCI image layout directory
+	OCILayout string
+	// Platform selects the os/arch[/variant] manifest of a multi-arch OCI layout
+	Platform string
+	// Keep selects the files whose content is held in memory when a local image is
+	// streamed from the daemon, the others can only be listed and stat'ed. Nil keeps
+	// them all. Archives and OCI layouts are read in place and keep every file
+	Keep KeepFunc
+}
+
+// KeepFunc tells whether the content of the file at name, of size bytes and beginning
+// with head, is needed by a scan. head holds the first 8 KiB of the file at most
+type KeepFunc func(name string, size int64, head []byte) bool
+
+// OpenImage returns the flattened filesystem of the image described by src, the
+// layers are indexed in place and nothing is extracted on disk. The caller must
+// close the returned ImageFS
+func OpenImage(src Source) (*ImageFS, error) {
+	switch {
+	case src.OCILayout != "":
+		return OpenOCILayout(src.OCILayout, src.Platform)
+	case src.Archive != "":
+		return OpenArchive(src.Archive)
+	case src.Image != "":
+		return OpenLocalImage(src.Image, src.Keep)
+	default:
+		return nil, fmt.Errorf("no image specified")
 	}
-	return nil
 }
 
-func ExtractImageLayers(imageName string) (string, error) {
-	workDir := "./tempdir"
-	err := os.MkdirAll(workDir, 0755)
+// OpenLocalImage streams a local image from the Docker Engine API and returns its
+// flattened filesystem. The layers are indexed as they come and nothing is written on
+// disk, the content of the files keep selects is held in memory, see Source.Keep
+func OpenLocalImage(imageName string, keep KeepFunc) (*ImageFS, error) {
+	client, err := NewEngineClient()
 	if err != nil {
-		return "", fmt.Errorf("create temp dir failed: %v", err)
+		return nil, err
 	}
+	stream, err := client.ImageSave(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("export image failed: %v", err)
+	}
+	defer stream.Close()
 
-	err = extractImage(imageName, workDir)
+	imageFS := newImageFS()
+	archive, err := indexStream(stream, imageName, imageFS, keep)
 	if err != nil {
-		return "", fmt.Errorf("extract image failed: %v", err)
+		return nil, err
 	}
+	return openArchive(archive, imageFS)
+}
 
-	layers, err := getLayersFromManifest(workDir)
+// OpenArchive returns the flattened filesystem of an image archive created by
+// `docker save`, without talking to a Docker daemon
+func OpenArchive(archivePath string) (*ImageFS, error) {
+	file, err := os.Open(archivePath)
 	if err != nil {
-		return "", fmt.Errorf("get image layers failed: %v", err)
+		return nil, fmt.Errorf("open image failed: %v", err)
 	}
+	imageFS := newImageFS()
+	imageFS.closers = append(imageFS.closers, file)
 
-	err = mountLayers(layers, workDir)
+	info, err := file.Stat()
+	if err != nil {
+		imageFS.Close()
+		return nil, fmt.Errorf("stat image archive failed: %v", err)
+	}
+	archive, err := indexArchive(io.NewSectionReader(file, 0, info.Size()), filepath.Base(archivePath), imageFS)
 	if err != nil {
-		return "", fmt.Errorf("get image layers failed: %v", err)
+		imageFS.Close()
+		return nil, err
+	}
+	return openArchive(archive, imageFS)
+}
+
+// openArchive adds the layers of an indexed image archive to imageFS
+func openArchive(archive *imageArchive, imageFS *ImageFS) (*ImageFS, error) {
+	layers, err := getLayersFromManifest(archive)
+	if err != nil {
+		imageFS.Close()
+		return nil, fmt.Errorf("get image layers failed: %v", err)
+	}
+
+	return addLayers(imageFS, layers)
+}
+
+// OpenOCILayout returns the flattened filesystem of the image stored in an OCI image
+// layout directory, platform selects the manifest of a multi-arch index
+func OpenOCILayout(layoutDir, platform string) (*ImageFS, error) {
+	paths, config, err := getLayersFromOCILayout(layoutDir, platform)
+	if err != nil {
+		return nil, fmt.Errorf("get image layers failed: %v", err)
+	}
+
+	imageFS := newImageFS()
+	var layers []*Layer
+	for i, layerPath := range paths {
+		file, err := os.Open(layerPath)
+		if err != nil {
+			imageFS.Close()
+			return nil, fmt.Errorf("open layer failed: %v", err)
+		}
+		imageFS.closers = append(imageFS.closers, file)
+
+		info, err := file.Stat()
+		if err != nil {
+			imageFS.Close()
+			return nil, fmt.Errorf("stat layer failed: %v", err)
+		}
+		name, err := filepath.Rel(layoutDir, layerPath)
+		if err != nil {
+			name = layerPath
+		}
+		layers = append(layers, &Layer{
+			Name:  filepath.ToSlash(name),
+			Index: i,
+			blob:  file,
+			size:  info.Size(),
+		})
 	}
 
-	return workDir, nil
+	if config != nil {
+		describeLayers(layers, config)
+	}
+	return addLayers(imageFS, layers)
+}
+
+func addLayers(imageFS *ImageFS, layers []*Layer) (*ImageFS, error) {
+	for _, layer := range layers {
+		err := imageFS.addLayer(layer)
+		if err != nil {
+			imageFS.Close()
+			return nil, fmt.Errorf("get image layers failed: %v", err)
+		}
+	}
+	return imageFS, nil
 }
@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildArchive builds an image archive in the `docker save` format holding layers,
+// followed by the extra entries
+func buildArchive(t *testing.T, layers [][]byte, extra ...tarEntry) []byte {
+	t.Helper()
+	config := imageConfig{}
+	var manifest struct {
+		Config string
+		Layers []string
+	}
+	manifest.Config = "config.json"
+	var entries []tarEntry
+	for i, layer := range layers {
+		name := filepath.ToSlash(filepath.Join(string(rune('a'+i)), "layer.tar"))
+		manifest.Layers = append(manifest.Layers, name)
+		entries = append(entries, dirEntry(filepath.Dir(name)+"/"), fileEntry(name, string(layer)))
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, "sha256:"+string(rune('a'+i))+"123456789abcdef")
+		config.History = append(config.History, struct {
+			CreatedBy  string `json:"created_by"`
+			EmptyLayer bool   `json:"empty_layer"`
+		}{CreatedBy: "/bin/sh -c #(nop) COPY file:" + name + " in / "})
+	}
+
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawManifest, err := json.Marshal([]interface{}{manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = append(entries, fileEntry("config.json", string(rawConfig)), fileEntry("manifest.json", string(rawManifest)))
+	return buildTar(t, append(entries, extra...)...)
+}
+
+func writeArchive(t *testing.T, archive []byte) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(archivePath, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestOpenArchive(t *testing.T) {
+	archive := buildArchive(t,
+		[][]byte{
+			buildTar(t, dirEntry("etc/"), fileEntry("etc/motd", "base")),
+			buildTar(t, fileEntry("etc/motd", "upper")),
+		},
+		fileEntry("../../escape", "x"),
+	)
+	imageFS, err := OpenArchive(writeArchive(t, archive))
+	if err != nil {
+		t.Fatalf("OpenArchive() error = %v", err)
+	}
+	defer imageFS.Close()
+
+	if got, err := imageFS.ReadFile("etc/motd"); err != nil || string(got) != "upper" {
+		t.Errorf("ReadFile(etc/motd) = %q, %v", got, err)
+	}
+	info, err := imageFS.Stat("etc/motd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := LayerOf(info)
+	if layer == nil || layer.Digest != "sha256:b123456789abcdef" || layer.Instruction() != "COPY file:b/layer.tar in /" {
+		t.Errorf("layer of etc/motd not described by the image config: %+v", layer)
+	}
+	if len(imageFS.UnsafeEntries) != 1 {
+		t.Fatalf("got %d unsafe entries, want 1", len(imageFS.UnsafeEntries))
+	}
+	entry := imageFS.UnsafeEntries[0]
+	if entry.Layer != nil || entry.Name != "../../escape" || entry.String() != reasonPathEscape+", image archive image.tar" {
+		t.Errorf("unsafe entry = %+v, %q", entry, entry.String())
+	}
+}
+
+func TestOpenArchiveSharedLayers(t *testing.T) {
+	// Older `docker save` versions link the layers shared between images
+	layer := buildTar(t, fileEntry("shared", "layer"))
+	archive := buildTar(t,
+		fileEntry("a/layer.tar", string(layer)),
+		symlinkEntry("b/layer.tar", "../a/layer.tar"),
+		hardlinkEntry("c/layer.tar", "b/layer.tar"),
+		symlinkEntry("d/layer.tar", "../../outside.tar"),
+		fileEntry("config.json", "{}"),
+		fileEntry("manifest.json", `[{"Config":"config.json","Layers":["b/layer.tar","c/layer.tar"]}]`),
+	)
+	archivePath := writeArchive(t, archive)
+	imageFS, err := OpenArchive(archivePath)
+	if err != nil {
+		t.Fatalf("OpenArchive() error = %v", err)
+	}
+	defer imageFS.Close()
+	if got, err := imageFS.ReadFile("shared"); err != nil || string(got) != "layer" {
+		t.Errorf("ReadFile(shared) = %q, %v", got, err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	indexed, err := indexArchive(io.NewSectionReader(file, 0, int64(len(archive))), "image.tar", newImageFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexed.open("d/layer.tar"); err == nil {
+		t.Errorf("open() of a layer linked outside of the archive succeeded, want an error")
+	}
+}
+
+func TestOpenArchiveStream(t *testing.T) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(buildTar(t, fileEntry("app/.wh.empty", ""), fileEntry("app/bin", "\x7fELF"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := buildArchive(t, [][]byte{
+		buildTar(t, fileEntry("app/config.yaml", "password: x"), fileEntry("app/empty", ""), fileEntry("app/big", "0123456789abcdef")),
+		compressed.Bytes(),
+		// An empty layer is a bare end-of-archive marker
+		make([]byte, 1024),
+	})
+
+	keep := func(name string, size int64, head []byte) bool {
+		return size < 16 && !bytes.HasPrefix(head, []byte("\x7fELF"))
+	}
+	imageFS := newImageFS()
+	indexed, err := indexStream(io.MultiReader(bytes.NewReader(archive)), "app:latest", imageFS, keep)
+	if err != nil {
+		t.Fatalf("indexStream() error = %v", err)
+	}
+	imageFS, err = openArchive(indexed, imageFS)
+	if err != nil {
+		t.Fatalf("openArchive() error = %v", err)
+	}
+	defer imageFS.Close()
+
+	if got, err := imageFS.ReadFile("app/config.yaml"); err != nil || string(got) != "password: x" {
+		t.Errorf("ReadFile(app/config.yaml) = %q, %v", got, err)
+	}
+	if _, err := imageFS.Stat("app/empty"); err == nil {
+		t.Errorf("app/empty was whited out, but still exists")
+	}
+	for _, name := range []string{"app/big", "app/bin"} {
+		if info, err := imageFS.Stat(name); err != nil || info.Size() == 0 {
+			t.Errorf("Stat(%s) = %v, %v", name, info, err)
+		}
+		if _, err := imageFS.ReadFile(name); !errors.Is(err, ErrNotKept) {
+			t.Errorf("ReadFile(%s) error = %v, want %v", name, err, ErrNotKept)
+		}
+	}
+	layers := imageFS.Layers()
+	if len(layers) != 3 || layers[1].Digest != "sha256:b123456789abcdef" {
+		t.Fatalf("layers not described by the image config: %v", layers)
+	}
+	var walked []string
+	err = layers[0].Walk(func(name string, header *tar.Header, content io.Reader) error {
+		walked = append(walked, name)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(walked, []string{"app/config.yaml", "app/empty"}) {
+		t.Errorf("Walk() = %v, %v, want the kept files", walked, err)
+	}
+
+	if _, err := indexStream(bytes.NewBuffer(archive[:len(archive)/2]), "app:latest", newImageFS(), nil); err == nil {
+		t.Errorf("indexStream() of a truncated stream succeeded, want an error")
+	}
+}
+
+func TestOpenArchiveStreamSharedLayers(t *testing.T) {
+	// Recent `docker save` versions name every blob by its digest, and link the classic layer paths to them
+	layer := buildTar(t, fileEntry("shared", "layer"))
+	archive := buildTar(t,
+		fileEntry("blobs/sha256/aaaa", string(layer)),
+		fileEntry("blobs/sha256/cccc", "{}"),
+		fileEntry("blobs/sha256/dddd", "not a layer"),
+		symlinkEntry("a/layer.tar", "../blobs/sha256/aaaa"),
+		fileEntry("manifest.json", `[{"Config":"blobs/sha256/cccc","Layers":["blobs/sha256/aaaa","a/layer.tar"]}]`),
+	)
+	imageFS := newImageFS()
+	indexed, err := indexStream(bytes.NewBuffer(archive), "app:latest", imageFS, nil)
+	if err != nil {
+		t.Fatalf("indexStream() error = %v", err)
+	}
+	if _, err := openArchive(indexed, imageFS); err != nil {
+		t.Fatalf("openArchive() error = %v", err)
+	}
+	if got, err := imageFS.ReadFile("shared"); err != nil || string(got) != "layer" {
+		t.Errorf("ReadFile(shared) = %q, %v", got, err)
+	}
+	if layers := imageFS.Layers(); len(layers) != 2 || layers[0] == layers[1] {
+		t.Errorf("got layers %v, want one per manifest entry", layers)
+	}
+
+	manifest := `[{"Layers":["blobs/sha256/dddd"]}]`
+	indexed.entries["manifest.json"] = archiveEntry{
+		typeflag: tar.TypeReg,
+		content:  io.NewSectionReader(strings.NewReader(manifest), 0, int64(len(manifest))),
+	}
+	if _, err := openArchive(indexed, newImageFS()); err == nil {
+		t.Errorf("openArchive() with a layer that is no tarball succeeded, want an error")
+	}
+}
+
+func TestReadArchiveConfig(t *testing.T) {
+	archive := buildArchive(t, [][]byte{buildTar(t, fileEntry("a", "a"))})
+	content, err := ReadArchiveConfig(writeArchive(t, archive))
+	if err != nil {
+		t.Fatalf("ReadArchiveConfig() error = %v", err)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(content, &config); err != nil || len(config.RootFS.DiffIDs) != 1 {
+		t.Errorf("ReadArchiveConfig() = %s, %v", content, err)
+	}
+
+	noManifest := writeArchive(t, buildTar(t, fileEntry("config.json", "{}")))
+	if _, err := ReadArchiveConfig(noManifest); err == nil {
+		t.Errorf("ReadArchiveConfig() of an archive without manifest.json succeeded, want an error")
+	}
+}
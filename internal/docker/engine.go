@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// EngineError is an error answered by the Docker Engine API
+type EngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("docker engine: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// EngineClient talks to the Docker Engine API, without the docker CLI
+type EngineClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewEngineClient returns a client for the daemon DOCKER_HOST points at, or for
+// /var/run/docker.sock when it is unset. unix:// and tcp:// hosts are supported, a
+// tcp:// host is reached over TLS when DOCKER_TLS_VERIFY or DOCKER_CERT_PATH is set
+func NewEngineClient() (*EngineClient, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("parse docker host %s failed: %v", host, err)
+	}
+
+	switch hostURL.Scheme {
+	case "unix":
+		socket := hostURL.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &EngineClient{
+			client: &http.Client{Transport: transport},
+			// The host is ignored when dialing the socket
+			baseURL: "http://docker",
+		}, nil
+	case "tcp":
+		config, err := tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return &EngineClient{
+				client:  &http.Client{},
+				baseURL: "http://" + hostURL.Host,
+			}, nil
+		}
+		return &EngineClient{
+			client:  &http.Client{Transport: &http.Transport{TLSClientConfig: config}},
+			baseURL: "https://" + hostURL.Host,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %s", host)
+	}
+}
+
+// tlsConfig returns the TLS configuration of a tcp:// host, or nil when neither
+// DOCKER_TLS_VERIFY nor DOCKER_CERT_PATH is set. Like the docker CLI, it reads ca.pem,
+// cert.pem and key.pem from DOCKER_CERT_PATH, ~/.docker by default. The daemon is
+// always verified against ca.pem
+func tlsConfig() (*tls.Config, error) {
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if os.Getenv("DOCKER_TLS_VERIFY") == "" && certPath == "" {
+		return nil, nil
+	}
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("find docker cert path failed: %v", err)
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("read docker CA certificate failed: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(certPath, "ca.pem"))
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("load docker client certificate failed: %v", err)
+	}
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// get sends a GET request to the Engine API, any answer but 200 is returned as an EngineError
+func (c *EngineClient) get(endpoint string) (*http.Response, error) {
+	requestURL := c.baseURL + (&url.URL{Path: endpoint}).EscapedPath()
+	resp, err := c.client.Get(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to docker engine failed: %v", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, &EngineError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+}
+
+// ImageInspect returns the low-level information of an image, as answered by GET /images/{name}/json
+func (c *EngineClient) ImageInspect(name string) ([]byte, error) {
+	return c.read("/images/"+name+"/json", "metadata", name)
+}
+
+// ImageHistory returns the history of an image, newest entry first, as answered by
+// GET /images/{name}/history. Unlike the history of an image config, its entries have
+// no empty_layer, and created is a Unix time
+func (c *EngineClient) ImageHistory(name string) ([]byte, error) {
+	return c.read("/images/"+name+"/history", "history", name)
+}
+
+// read returns the whole answer of a GET request about an image, what the answer holds
+// is only used in errors
+func (c *EngineClient) read(endpoint, what, name string) ([]byte, error) {
+	resp, err := c.get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read image %s %s failed: %v", name, what, err)
+	}
+	return content, nil
+}
+
+// ImageSave streams an image archive in the `docker save` format, as answered by
+// GET /images/{name}/get. The caller must close the returned stream
+func (c *EngineClient) ImageSave(name string) (io.ReadCloser, error) {
+	resp, err := c.get("/images/" + name + "/get")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
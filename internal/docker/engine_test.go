@@ -0,0 +1,315 @@
+package docker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEngine serves handler on a unix socket and points DOCKER_HOST at it
+func fakeEngine(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	// t.TempDir may exceed the length limit of a socket path
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+}
+
+func TestNewEngineClient(t *testing.T) {
+	tests := []struct {
+		host    string
+		baseURL string
+		wantErr bool
+	}{
+		{"", "http://docker", false},
+		{"unix:///run/user/1000/docker.sock", "http://docker", false},
+		{"tcp://127.0.0.1:2375", "http://127.0.0.1:2375", false},
+		{"ssh://user@host", "", true},
+		{"://", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			t.Setenv("DOCKER_HOST", tt.host)
+			t.Setenv("DOCKER_TLS_VERIFY", "")
+			t.Setenv("DOCKER_CERT_PATH", "")
+			client, err := NewEngineClient()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEngineClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && client.baseURL != tt.baseURL {
+				t.Errorf("baseURL = %q, want %q", client.baseURL, tt.baseURL)
+			}
+		})
+	}
+}
+
+// writeCert signs a certificate for template with parent, self-signed when parent is nil,
+// and writes it to name.pem and its key to keyName in dir
+func writeCert(t *testing.T, dir, name, keyName string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyName), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestNewEngineClientTLS(t *testing.T) {
+	certPath := t.TempDir()
+	serverDir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeCert(t, certPath, "ca", "ca-key.pem", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCert(t, certPath, "cert", "key.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	writeCert(t, serverDir, "server", "server-key.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "daemon"},
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(serverDir, "server.pem"), filepath.Join(serverDir, "server-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"sha256:abc"}`))
+	}))
+	// The daemon refuses clients without a certificate signed by its CA, as dockerd --tlsverify does
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	t.Setenv("DOCKER_HOST", "tcp://"+host)
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", certPath)
+	client, err := NewEngineClient()
+	if err != nil {
+		t.Fatalf("NewEngineClient() error = %v", err)
+	}
+	if client.baseURL != "https://"+host {
+		t.Errorf("baseURL = %q, want https://%s", client.baseURL, host)
+	}
+	if content, err := client.ImageInspect("app"); err != nil || string(content) != `{"Id":"sha256:abc"}` {
+		t.Errorf("ImageInspect() over TLS = %s, %v", content, err)
+	}
+
+	// A daemon whose certificate is not signed by ca.pem is refused
+	writeCert(t, certPath, "ca", "ca-key.pem", &x509.Certificate{
+		SerialNumber:          big.NewInt(4),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	client, err = NewEngineClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ImageInspect("app"); err == nil {
+		t.Errorf("ImageInspect() of a daemon not signed by ca.pem succeeded, want an error")
+	}
+
+	os.Remove(filepath.Join(certPath, "key.pem"))
+	if _, err := NewEngineClient(); err == nil || !strings.Contains(err.Error(), "load docker client certificate failed") {
+		t.Errorf("NewEngineClient() without key.pem error = %v", err)
+	}
+	t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+	if _, err := NewEngineClient(); err == nil || !strings.Contains(err.Error(), "read docker CA certificate failed") {
+		t.Errorf("NewEngineClient() without ca.pem error = %v", err)
+	}
+}
+
+func TestEngineClientImageInspect(t *testing.T) {
+	var requested string
+	fakeEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		switch r.URL.Path {
+		case "/images/registry.example.com/app:1.0/json":
+			w.Write([]byte(`{"Id":"sha256:abc"}`))
+		case "/images/missing/json":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image: missing"}`))
+		case "/images/text/json":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("daemon unavailable\n"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	client, err := NewEngineClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		image   string
+		want    string
+		wantErr *EngineError
+	}{
+		{"registry.example.com/app:1.0", `{"Id":"sha256:abc"}`, nil},
+		{"missing", "", &EngineError{StatusCode: http.StatusNotFound, Message: "No such image: missing"}},
+		{"text", "", &EngineError{StatusCode: http.StatusInternalServerError, Message: "daemon unavailable"}},
+		{"empty", "", &EngineError{StatusCode: http.StatusInternalServerError, Message: "Internal Server Error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			content, err := client.ImageInspect(tt.image)
+			if requested != "/images/"+tt.image+"/json" {
+				t.Errorf("requested %s", requested)
+			}
+			if tt.wantErr != nil {
+				var engineErr *EngineError
+				if !errors.As(err, &engineErr) || *engineErr != *tt.wantErr {
+					t.Errorf("ImageInspect() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(content) != tt.want {
+				t.Errorf("ImageInspect() = %s, %v, want %s", content, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineClientImageHistory(t *testing.T) {
+	const history = `[{"Created":1700000000,"CreatedBy":"ENV TOKEN=abc","Comment":""}]`
+	fakeEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/app:latest/history" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image"}`))
+			return
+		}
+		w.Write([]byte(history))
+	})
+	client, err := NewEngineClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := client.ImageHistory("app:latest"); err != nil || string(content) != history {
+		t.Errorf("ImageHistory() = %s, %v, want %s", content, err, history)
+	}
+	var engineErr *EngineError
+	if _, err := client.ImageHistory("missing"); !errors.As(err, &engineErr) || engineErr.StatusCode != http.StatusNotFound {
+		t.Errorf("ImageHistory(missing) error = %v, want a 404", err)
+	}
+}
+
+func TestEngineClientImageSave(t *testing.T) {
+	archive := buildArchive(t, [][]byte{
+		buildTar(t, dirEntry("root/"), fileEntry("root/.netrc", "machine x password y")),
+	})
+	fakeEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/app:latest/get" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image"}`))
+			return
+		}
+		// Send the archive in chunks, as the daemon streams it
+		for rest := archive; len(rest) > 0; {
+			n := min(len(rest), 1000)
+			w.Write(rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	})
+	client, err := NewEngineClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.ImageSave("app:latest")
+	if err != nil {
+		t.Fatalf("ImageSave() error = %v", err)
+	}
+	content, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil || len(content) != len(archive) {
+		t.Errorf("ImageSave() streamed %d bytes, %v, want %d", len(content), err, len(archive))
+	}
+
+	imageFS, err := OpenLocalImage("app:latest", nil)
+	if err != nil {
+		t.Fatalf("OpenLocalImage() error = %v", err)
+	}
+	defer imageFS.Close()
+	if got, err := imageFS.ReadFile("root/.netrc"); err != nil || string(got) != "machine x password y" {
+		t.Errorf("ReadFile(root/.netrc) = %q, %v", got, err)
+	}
+
+	_, err = OpenLocalImage("missing", nil)
+	if err == nil || !strings.Contains(err.Error(), "No such image (status 404)") {
+		t.Errorf("OpenLocalImage(missing) error = %v, want the answer of the engine", err)
+	}
+}
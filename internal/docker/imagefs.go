@@ -0,0 +1,548 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops bounds symlink resolution the same way the Linux kernel does
+const maxSymlinkHops = 40
+
+var (
+	errNotDir      = errors.New("not a directory")
+	errIsDir       = errors.New("is a directory")
+	errSymlinkLoop = errors.New("too many levels of symbolic links")
+
+	// ErrNotKept is returned when reading a file of a streamed image that Source.Keep did not select
+	ErrNotKept = errors.New("content not kept from the image stream")
+)
+
+// FileMeta records the attributes of an image entry as stored in the layer tarball,
+// it is returned by the Sys method of the FileInfo of an ImageFS entry
+type FileMeta struct {
+	// Mode holds the permission bits, the setuid/setgid/sticky bits and the file type
+	Mode fs.FileMode
+	Uid  int
+	Gid  int
+	// Linkname is the target of a symlink or hardlink
+	Linkname string
+	// Xattrs holds the extended attributes recorded in PAX headers, such as security.capability
+	Xattrs map[string]string
+	// Layer is the layer that last wrote the entry, or that created a directory its
+	// entries implied. It is nil for the image root unless a layer declared it
+	Layer *Layer
+}
+
+func newFileMeta(layer *Layer, header *tar.Header) FileMeta {
+	meta := FileMeta{
+		Layer:    layer,
+		Mode:     header.FileInfo().Mode(),
+		Uid:      header.Uid,
+		Gid:      header.Gid,
+		Linkname: header.Linkname,
+	}
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			if meta.Xattrs == nil {
+				meta.Xattrs = make(map[string]string)
+			}
+			meta.Xattrs[name] = value
+		}
+	}
+	return meta
+}
+
+// LayerOf returns the layer that last wrote the entry described by info, or nil
+// if info does not come from an ImageFS
+func LayerOf(info fs.FileInfo) *Layer {
+	if meta, ok := info.Sys().(*FileMeta); ok {
+		return meta.Layer
+	}
+	return nil
+}
+
+// ReadLinkFS is implemented by file systems that expose symlinks instead of following them
+type ReadLinkFS interface {
+	fs.FS
+	// ReadLink returns the target of the symlink name
+	ReadLink(name string) (string, error)
+	// Lstat returns the FileInfo of name without following a final symlink
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// node is an entry of the flattened filesystem
+type node struct {
+	meta    FileMeta
+	modTime time.Time
+
+	// The content of a regular file lives at offset in view, or in data for the
+	// sparse files that cannot be served from the tarball as is and the files kept
+	// from a stream. discarded is set for the files of a stream that were not kept
+	view      io.ReaderAt
+	offset    int64
+	size      int64
+	data      []byte
+	discarded bool
+
+	children map[string]*node
+}
+
+func newDirNode(layer *Layer) *node {
+	return &node{
+		meta:     FileMeta{Mode: fs.ModeDir | 0755, Layer: layer},
+		children: make(map[string]*node),
+	}
+}
+
+func (n *node) isDir() bool {
+	return n.meta.Mode.IsDir()
+}
+
+func (n *node) isSymlink() bool {
+	return n.meta.Mode&fs.ModeSymlink != 0
+}
+
+func (n *node) content() io.Reader {
+	if n.data != nil {
+		return bytes.NewReader(n.data)
+	}
+	if n.view == nil {
+		return bytes.NewReader(nil)
+	}
+	return io.NewSectionReader(n.view, n.offset, n.size)
+}
+
+// ImageFS is a read-only view of the flattened filesystem of an image. It indexes
+// the layer tarballs in memory, applies the overlay semantics of a container runtime
+// and reads file contents from the tarballs on demand, nothing is extracted on disk.
+// Symlinks are resolved inside the image, never on the scanning host
+type ImageFS struct {
+	// UnsafeEntries lists the entries that tried to reach outside of the image root
+	UnsafeEntries []UnsafeEntry
+
+	layers  []*Layer
+	root    *node
+	closers []io.Closer
+}
+
+var (
+	_ fs.StatFS     = (*ImageFS)(nil)
+	_ fs.ReadDirFS  = (*ImageFS)(nil)
+	_ fs.ReadFileFS = (*ImageFS)(nil)
+	_ ReadLinkFS    = (*ImageFS)(nil)
+)
+
+func newImageFS() *ImageFS {
+	return &ImageFS{
+		root: newDirNode(nil),
+	}
+}
+
+// isSparse reports whether the content of an entry is stored in one of the sparse formats
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// addLayer indexes the entries of a layer on top of the layers added so far
+func (f *ImageFS) addLayer(layer *Layer) error {
+	if layer.streamed {
+		f.addStreamedLayer(layer)
+		return nil
+	}
+
+	stream, err := layer.open()
+	if err != nil {
+		return err
+	}
+	if stream.closer != nil {
+		f.closers = append(f.closers, stream.closer)
+	}
+
+	tarReader := tar.NewReader(stream.reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read layer %s failed: %v", layer.Name, err)
+		}
+
+		entry := &node{
+			meta:    newFileMeta(layer, header),
+			modTime: header.ModTime,
+		}
+		switch {
+		case isSparse(header):
+			entry.data, err = io.ReadAll(tarReader)
+			if err != nil {
+				return fmt.Errorf("read layer %s failed: %v", layer.Name, err)
+			}
+			entry.size = int64(len(entry.data))
+		case header.Typeflag == tar.TypeReg:
+			entry.view = stream.view
+			entry.offset = stream.offset()
+			entry.size = header.Size
+		}
+		f.apply(layer, header, entry)
+	}
+
+	layer.view, layer.viewSize = stream.view, stream.offset()
+	f.layers = append(f.layers, layer)
+	return nil
+}
+
+// addStreamedLayer applies the entries of a layer indexed from a stream on top of the
+// layers added so far
+func (f *ImageFS) addStreamedLayer(layer *Layer) {
+	for _, indexed := range layer.entries {
+		header := indexed.header
+		entry := &node{
+			meta:    newFileMeta(layer, header),
+			modTime: header.ModTime,
+		}
+		if header.Typeflag == tar.TypeReg || isSparse(header) {
+			entry.data = indexed.data
+			entry.size = header.Size
+			entry.discarded = !indexed.kept
+		}
+		f.apply(layer, header, entry)
+	}
+	f.layers = append(f.layers, layer)
+}
+
+// apply adds an entry of layer to the tree, or applies it as a whiteout
+func (f *ImageFS) apply(layer *Layer, header *tar.Header, entry *node) {
+	name, ok := safeName(header.Name)
+	if !ok {
+		f.addUnsafe(layer, header, reasonPathEscape)
+		return
+	}
+	if f.applyWhiteout(layer, name) {
+		return
+	}
+	f.addEntry(layer, name, header, entry)
+}
+
+// Layers returns the layers of the image, from the base layer up
+func (f *ImageFS) Layers() []*Layer {
+	return f.layers
+}
+
+// addEntry places an entry in the tree, replacing what the layers below left at the same path
+func (f *ImageFS) addEntry(layer *Layer, name string, header *tar.Header, entry *node) {
+	if name == "" {
+		if header.Typeflag != tar.TypeDir {
+			f.addUnsafe(layer, header, reasonRootReplace)
+			return
+		}
+		f.root.meta, f.root.modTime = entry.meta, entry.modTime
+		return
+	}
+
+	parent, escaped, err := f.walk(path.Dir(name), true, layer)
+	if err != nil {
+		// A runtime would refuse the layer, the entry is skipped so that the rest of the image is still scanned
+		return
+	}
+	if escaped {
+		f.addUnsafe(layer, header, reasonWriteThrough)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeLink:
+		linkName, ok := safeName(header.Linkname)
+		if !ok {
+			f.addUnsafe(layer, header, reasonHardlinkEscape)
+			return
+		}
+		target, escaped, err := f.walk(linkName, false, nil)
+		if escaped {
+			f.addUnsafe(layer, header, reasonHardlinkEscape)
+		}
+		if err != nil || target.isDir() {
+			return
+		}
+		// A hardlink shares the inode, and therefore the attributes and content, of its target
+		linked := *target
+		linked.meta.Linkname = header.Linkname
+		linked.meta.Layer = layer
+		entry = &linked
+	case tar.TypeSymlink:
+		if symlinkEscapes(path.Dir(name), header.Linkname) {
+			f.addUnsafe(layer, header, reasonSymlinkEscape)
+		}
+	}
+
+	base := path.Base(name)
+	existing := parent.children[base]
+	if entry.isDir() {
+		if existing != nil && existing.isDir() {
+			existing.meta, existing.modTime = entry.meta, entry.modTime
+			return
+		}
+		entry.children = make(map[string]*node)
+	}
+	parent.children[base] = entry
+}
+
+// walk resolves name from the root of the image, following the symlinks of every
+// component and, if followLast is set, of the last one. When create is set, missing
+// directories are created on behalf of that layer. walk also reports whether the path
+// climbed above the image root through .., an absolute symlink target is resolved
+// against the image root, as a container runtime does, and does not escape it
+func (f *ImageFS) walk(name string, followLast bool, create *Layer) (*node, bool, error) {
+	stack := []*node{f.root}
+	escaped := false
+	pending := strings.Split(name, "/")
+	hops := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		current := stack[len(stack)-1]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) == 1 {
+				escaped = true
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		if !current.isDir() {
+			return nil, escaped, errNotDir
+		}
+		child, ok := current.children[part]
+		if !ok {
+			if create == nil {
+				return nil, escaped, fs.ErrNotExist
+			}
+			child = newDirNode(create)
+			current.children[part] = child
+		}
+
+		if child.isSymlink() && (len(pending) > 0 || followLast) {
+			hops++
+			if hops > maxSymlinkHops {
+				return nil, escaped, errSymlinkLoop
+			}
+			if path.IsAbs(child.meta.Linkname) {
+				stack = stack[:1]
+			}
+			pending = append(strings.Split(child.meta.Linkname, "/"), pending...)
+			continue
+		}
+		stack = append(stack, child)
+	}
+	return stack[len(stack)-1], escaped, nil
+}
+
+func (f *ImageFS) lookup(op, name string, followLast bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, _, err := f.walk(name, followLast, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return n, nil
+}
+
+// Open opens the named file, following symlinks inside the image
+func (f *ImageFS) Open(name string) (fs.File, error) {
+	n, err := f.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &fileInfo{name: path.Base(name), node: n}
+	if n.isDir() {
+		return &dirFile{info: info, entries: readDir(n)}, nil
+	}
+	if n.discarded {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrNotKept}
+	}
+	return &file{info: info, reader: n.content()}, nil
+}
+
+// Stat returns the FileInfo of the named file, following symlinks inside the image
+func (f *ImageFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), node: n}, nil
+}
+
+// Lstat returns the FileInfo of the named file without following a final symlink
+func (f *ImageFS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), node: n}, nil
+}
+
+// ReadLink returns the target of the named symlink, as recorded in the layer
+func (f *ImageFS) ReadLink(name string) (string, error) {
+	n, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !n.isSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.meta.Linkname, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (f *ImageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return readDir(n), nil
+}
+
+// ReadFile returns the content of the named file
+func (f *ImageFS) ReadFile(name string) ([]byte, error) {
+	n, err := f.lookup("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	if n.discarded {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrNotKept}
+	}
+	return io.ReadAll(n.content())
+}
+
+// Close releases the image archive and the files backing the layers
+func (f *ImageFS) Close() error {
+	var errs []error
+	for _, closer := range f.closers {
+		errs = append(errs, closer.Close())
+	}
+	f.closers = nil
+	return errors.Join(errs...)
+}
+
+func readDir(n *node) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for name, child := range n.children {
+		entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{name: name, node: child}))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// fileInfo describes an ImageFS entry, Sys returns its *FileMeta
+type fileInfo struct {
+	name string
+	node *node
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	if i.node.isSymlink() {
+		return int64(len(i.node.meta.Linkname))
+	}
+	return i.node.size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	return i.node.meta.Mode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return i.node.modTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.node.isDir()
+}
+
+func (i *fileInfo) Sys() any {
+	return &i.node.meta
+}
+
+type file struct {
+	info   *fileInfo
+	reader io.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+type dirFile struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
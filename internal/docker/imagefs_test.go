@@ -0,0 +1,378 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// tarEntry is an entry of a tarball built by a test
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func fileEntry(name, content string) tarEntry {
+	return tarEntry{name: name, typeflag: tar.TypeReg, content: content}
+}
+
+func dirEntry(name string) tarEntry {
+	return tarEntry{name: name, typeflag: tar.TypeDir}
+}
+
+func symlinkEntry(name, linkname string) tarEntry {
+	return tarEntry{name: name, typeflag: tar.TypeSymlink, linkname: linkname}
+}
+
+func hardlinkEntry(name, linkname string) tarEntry {
+	return tarEntry{name: name, typeflag: tar.TypeLink, linkname: linkname}
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Size:     int64(len(entry.content)),
+			Mode:     0644,
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openLayers(t *testing.T, layers ...[]byte) *ImageFS {
+	t.Helper()
+	var indexed []*Layer
+	for i, blob := range layers {
+		indexed = append(indexed, &Layer{
+			Name:  fmt.Sprintf("layer%d.tar", i),
+			Index: i,
+			blob:  bytes.NewReader(blob),
+			size:  int64(len(blob)),
+		})
+	}
+	imageFS, err := addLayers(newImageFS(), indexed)
+	if err != nil {
+		t.Fatalf("addLayers() error = %v", err)
+	}
+	t.Cleanup(func() { imageFS.Close() })
+	return imageFS
+}
+
+func unsafeReasons(imageFS *ImageFS) []string {
+	var reasons []string
+	for _, entry := range imageFS.UnsafeEntries {
+		reasons = append(reasons, entry.Reason)
+	}
+	return reasons
+}
+
+func TestImageFSLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers [][]tarEntry
+		// files maps the paths expected in the image to their content
+		files  map[string]string
+		absent []string
+		unsafe []string
+	}{
+		{
+			name: "upper layer overwrites",
+			layers: [][]tarEntry{
+				{dirEntry("etc/"), fileEntry("etc/motd", "base")},
+				{fileEntry("etc/motd", "upper")},
+			},
+			files: map[string]string{"etc/motd": "upper"},
+		},
+		{
+			name: "implied directories",
+			layers: [][]tarEntry{
+				{fileEntry("usr/local/bin/app", "elf")},
+			},
+			files: map[string]string{"usr/local/bin/app": "elf"},
+		},
+		{
+			name: "whiteout of a file",
+			layers: [][]tarEntry{
+				{dirEntry("etc/"), fileEntry("etc/passwd", "root"), fileEntry("etc/shadow", "hash")},
+				{fileEntry("etc/.wh.shadow", "")},
+			},
+			files:  map[string]string{"etc/passwd": "root"},
+			absent: []string{"etc/shadow", "etc/.wh.shadow"},
+		},
+		{
+			name: "whiteout of a directory",
+			layers: [][]tarEntry{
+				{dirEntry("opt/"), dirEntry("opt/app/"), fileEntry("opt/app/id_rsa", "key")},
+				{fileEntry("opt/.wh.app", "")},
+			},
+			absent: []string{"opt/app", "opt/app/id_rsa"},
+		},
+		{
+			name: "whiteout then recreated",
+			layers: [][]tarEntry{
+				{fileEntry("app.conf", "old")},
+				{fileEntry(".wh.app.conf", "")},
+				{fileEntry("app.conf", "new")},
+			},
+			files: map[string]string{"app.conf": "new"},
+		},
+		{
+			name: "whiteout of a missing entry",
+			layers: [][]tarEntry{
+				{fileEntry("a", "a")},
+				{fileEntry("missing/.wh.b", ""), fileEntry(".wh..", "")},
+			},
+			files: map[string]string{"a": "a"},
+		},
+		{
+			name: "opaque directory after the layer's own entries",
+			layers: [][]tarEntry{
+				{dirEntry("var/"), dirEntry("var/lib/"), fileEntry("var/lib/old", "old"), dirEntry("var/lib/sub/"), fileEntry("var/lib/sub/old", "old"), fileEntry("var/keep", "keep")},
+				{dirEntry("var/lib/"), fileEntry("var/lib/new", "new"), fileEntry("var/lib/sub/new", "new"), fileEntry("var/lib/.wh..wh..opq", "")},
+			},
+			files:  map[string]string{"var/lib/new": "new", "var/lib/sub/new": "new", "var/keep": "keep"},
+			absent: []string{"var/lib/old", "var/lib/sub/old", "var/lib/.wh..wh..opq"},
+		},
+		{
+			name: "opaque directory before the layer's own entries",
+			layers: [][]tarEntry{
+				{dirEntry("var/"), dirEntry("var/lib/"), fileEntry("var/lib/old", "old")},
+				{dirEntry("var/lib/"), fileEntry("var/lib/.wh..wh..opq", ""), fileEntry("var/lib/new", "new")},
+			},
+			files:  map[string]string{"var/lib/new": "new"},
+			absent: []string{"var/lib/old"},
+		},
+		{
+			name: "other whiteout metadata",
+			layers: [][]tarEntry{
+				{dirEntry(".wh..wh.plnk/"), dirEntry(".wh..wh.orph/"), fileEntry("a", "a")},
+			},
+			files:  map[string]string{"a": "a"},
+			absent: []string{".wh..wh.plnk"},
+		},
+		{
+			name: "path escaping through ..",
+			layers: [][]tarEntry{
+				{fileEntry("../../etc/cron.d/evil", "* * * * * root sh"), fileEntry("tmp/../../x", "x")},
+			},
+			absent: []string{"etc/cron.d/evil", "x"},
+			unsafe: []string{reasonPathEscape, reasonPathEscape},
+		},
+		{
+			name: "absolute names stay inside the root",
+			layers: [][]tarEntry{
+				{fileEntry("/etc/hosts", "localhost"), fileEntry("./a/../b", "b")},
+			},
+			files: map[string]string{"etc/hosts": "localhost", "b": "b"},
+		},
+		{
+			name: "symlink escaping through ..",
+			layers: [][]tarEntry{
+				{dirEntry("tmp/"), symlinkEntry("tmp/up", "../../..")},
+				{fileEntry("tmp/up/etc/cron.d/evil", "* * * * * root sh")},
+			},
+			files:  map[string]string{"etc/cron.d/evil": "* * * * * root sh"},
+			unsafe: []string{reasonSymlinkEscape, reasonWriteThrough},
+		},
+		{
+			name: "absolute symlink resolved against the image root",
+			layers: [][]tarEntry{
+				{dirEntry("run/"), dirEntry("var/"), symlinkEntry("var/run", "/run")},
+				{fileEntry("var/run/app.pid", "1")},
+			},
+			files: map[string]string{"run/app.pid": "1", "var/run/app.pid": "1"},
+		},
+		{
+			name: "relative symlink inside the root",
+			layers: [][]tarEntry{
+				{dirEntry("usr/"), dirEntry("usr/lib/"), symlinkEntry("lib", "usr/lib"), fileEntry("lib/libc.so", "libc")},
+			},
+			files: map[string]string{"usr/lib/libc.so": "libc"},
+		},
+		{
+			name: "hardlink",
+			layers: [][]tarEntry{
+				{fileEntry("etc/passwd", "root"), hardlinkEntry("etc/passwd-", "etc/passwd")},
+			},
+			files: map[string]string{"etc/passwd-": "root"},
+		},
+		{
+			name: "hardlink escaping the root",
+			layers: [][]tarEntry{
+				{hardlinkEntry("shadow", "../../etc/shadow")},
+			},
+			absent: []string{"shadow"},
+			unsafe: []string{reasonHardlinkEscape},
+		},
+		{
+			name: "hardlink escaping through a symlink",
+			layers: [][]tarEntry{
+				{fileEntry("secret", "s"), symlinkEntry("up", ".."), hardlinkEntry("link", "up/secret")},
+			},
+			files:  map[string]string{"link": "s"},
+			unsafe: []string{reasonSymlinkEscape, reasonHardlinkEscape},
+		},
+		{
+			name: "root replaced",
+			layers: [][]tarEntry{
+				{symlinkEntry("./", "/etc"), fileEntry("a", "a")},
+			},
+			files:  map[string]string{"a": "a"},
+			unsafe: []string{reasonRootReplace},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers [][]byte
+			for _, entries := range tt.layers {
+				layers = append(layers, buildTar(t, entries...))
+			}
+			imageFS := openLayers(t, layers...)
+
+			for name, want := range tt.files {
+				got, err := imageFS.ReadFile(name)
+				if err != nil {
+					t.Errorf("ReadFile(%s) error = %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("ReadFile(%s) = %q, want %q", name, got, want)
+				}
+			}
+			for _, name := range tt.absent {
+				if _, err := imageFS.Lstat(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Lstat(%s) error = %v, want it to not exist", name, err)
+				}
+			}
+			if got := unsafeReasons(imageFS); !reflect.DeepEqual(got, tt.unsafe) {
+				t.Errorf("unsafe entries = %q, want %q", got, tt.unsafe)
+			}
+		})
+	}
+}
+
+func TestImageFSSymlinks(t *testing.T) {
+	imageFS := openLayers(t, buildTar(t,
+		symlinkEntry("loop1", "loop2"),
+		symlinkEntry("loop2", "loop1"),
+		fileEntry("file", "content"),
+		symlinkEntry("link", "file"),
+		symlinkEntry("dangling", "missing"),
+	))
+
+	if _, err := imageFS.Stat("loop1"); !errors.Is(err, errSymlinkLoop) {
+		t.Errorf("Stat(loop1) error = %v, want %v", err, errSymlinkLoop)
+	}
+	if target, err := imageFS.ReadLink("loop1"); err != nil || target != "loop2" {
+		t.Errorf("ReadLink(loop1) = %q, %v", target, err)
+	}
+	if _, err := imageFS.Stat("dangling"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(dangling) error = %v, want it to not exist", err)
+	}
+	if info, err := imageFS.Lstat("link"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat(link) = %v, %v, want a symlink", info, err)
+	}
+	if _, err := imageFS.ReadLink("file"); err == nil {
+		t.Errorf("ReadLink(file) succeeded, want an error")
+	}
+	if _, err := imageFS.ReadFile("file/x"); !errors.Is(err, errNotDir) {
+		t.Errorf("ReadFile(file/x) error = %v, want %v", err, errNotDir)
+	}
+}
+
+func TestImageFSCompressedLayer(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(buildTar(t, dirEntry("etc/"), fileEntry("etc/motd", "compressed"), fileEntry("etc/issue", "welcome")))
+	gz.Close()
+
+	imageFS := openLayers(t, buildTar(t, fileEntry("etc/motd", "plain")), compressed.Bytes())
+	if err := fstest.TestFS(imageFS, "etc/motd", "etc/issue"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := imageFS.ReadFile("etc/motd"); string(got) != "compressed" {
+		t.Errorf("ReadFile(etc/motd) = %q, want the upper layer", got)
+	}
+	info, err := imageFS.Stat("etc/issue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer := LayerOf(info); layer == nil || layer.Index != 1 {
+		t.Errorf("LayerOf(etc/issue) = %v, want layer 1", layer)
+	}
+}
+
+func TestImageFSMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0750},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/bin/ping", Typeflag: tar.TypeReg, Mode: 04755, PAXRecords: map[string]string{
+			"SCHILY.xattr.security.capability": "cap_net_raw",
+		}},
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000, Gid: 1000},
+		{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	}
+	for _, header := range headers {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	imageFS := openLayers(t, buf.Bytes())
+
+	meta := func(name string) *FileMeta {
+		t.Helper()
+		info, err := imageFS.Lstat(name)
+		if err != nil {
+			t.Fatalf("Lstat(%s) error = %v", name, err)
+		}
+		return info.Sys().(*FileMeta)
+	}
+	if ping := meta("usr/bin/ping"); ping.Mode&fs.ModeSetuid == 0 || ping.Xattrs["security.capability"] != "cap_net_raw" {
+		t.Errorf("ping metadata = %+v, want setuid and its capability", ping)
+	}
+	if tool := meta("usr/bin/tool"); tool.Uid != 1000 || tool.Gid != 1000 {
+		t.Errorf("tool metadata = %+v, want owned by 1000:1000", tool)
+	}
+	if etc := meta("etc"); etc.Mode.Perm() != 0750 {
+		t.Errorf("etc metadata = %+v, want mode 0750", etc)
+	}
+	if link := meta("passwd"); link.Linkname != "/etc/passwd" {
+		t.Errorf("passwd metadata = %+v, want the symlink", link)
+	}
+
+	// An absolute target is resolved inside the image, never on the scanning host
+	info, err := imageFS.Stat("passwd")
+	if err != nil || info.Mode() != 0644 {
+		t.Errorf("Stat(passwd) = %v, %v, want etc/passwd of the image", info, err)
+	}
+}
@@ -0,0 +1,321 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Layer is a layer tarball of an image
+type Layer struct {
+	// Name identifies the layer in its source, like its path in an image archive
+	Name string
+	// Index is the position of the layer, the base layer is 0
+	Index int
+	// Digest is the digest of the uncompressed layer tarball, as listed in the image config
+	Digest string
+	// CreatedBy is the command of the image history entry that created the layer
+	CreatedBy string
+
+	blob io.ReaderAt
+	size int64
+
+	// streamed is set for a layer indexed as it was read from a stream, entries then
+	// holds its tar entries and the file contents that were kept
+	streamed bool
+	entries  []layerEntry
+
+	// view holds the uncompressed tarball once the layer is indexed
+	view     io.ReaderAt
+	viewSize int64
+}
+
+// String identifies the layer by its index and short digest
+func (l *Layer) String() string {
+	if l == nil {
+		return ""
+	}
+	id := l.Name
+	if hex, ok := strings.CutPrefix(l.Digest, "sha256:"); ok && len(hex) >= 12 {
+		id = hex[:12]
+	}
+	return fmt.Sprintf("#%d %s", l.Index, id)
+}
+
+// Instruction returns the Dockerfile instruction that created the layer, as far as
+// the builder recorded it in the image history
+func (l *Layer) Instruction() string {
+	if l == nil {
+		return ""
+	}
+	createdBy := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(l.CreatedBy), "# buildkit"))
+	if instruction, ok := strings.CutPrefix(createdBy, "/bin/sh -c #(nop)"); ok {
+		return strings.TrimSpace(instruction)
+	}
+	if command, ok := strings.CutPrefix(createdBy, "/bin/sh -c "); ok {
+		// The classic builder records RUN instructions as the bare command
+		return "RUN " + command
+	}
+	return createdBy
+}
+
+// Walk calls fn for every regular file of the layer tarball alone, including the files
+// that later layers delete or overwrite. name is relative to the image root and content
+// is only valid until fn returns. Walk reads the layer from its ImageFS, which must be open.
+// For an image streamed from the Docker daemon, only the files Source.Keep selected are walked
+func (l *Layer) Walk(fn func(name string, header *tar.Header, content io.Reader) error) error {
+	if l.streamed {
+		for _, entry := range l.entries {
+			name, ok := walkName(entry.header)
+			if !ok || !entry.kept {
+				continue
+			}
+			err := fn(name, entry.header, bytes.NewReader(entry.data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if l.view == nil {
+		return fmt.Errorf("layer %s is not indexed", l.Name)
+	}
+
+	tarReader := tar.NewReader(io.NewSectionReader(l.view, 0, l.viewSize))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read layer %s failed: %v", l.Name, err)
+		}
+		name, ok := walkName(header)
+		if !ok {
+			continue
+		}
+		err = fn(name, header, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkName returns the name of the regular file header describes, relative to the image
+// root, and false for the other entries, whiteouts and names escaping the root
+func walkName(header *tar.Header) (string, bool) {
+	if header.Typeflag != tar.TypeReg && !isSparse(header) {
+		return "", false
+	}
+	name, ok := safeName(header.Name)
+	if !ok || name == "" || strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+		return "", false
+	}
+	return name, true
+}
+
+// imageConfig holds the parts of the image config that describe the layers
+type imageConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		CreatedBy  string `json:"created_by"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// describeLayers records the diff IDs and the history of config on layers. History
+// entries are matched to layers in order, skipping the ones that created no layer,
+// and are ignored when they do not add up to the layers of the image
+func describeLayers(layers []*Layer, config *imageConfig) {
+	if len(config.RootFS.DiffIDs) == len(layers) {
+		for i, layer := range layers {
+			layer.Digest = config.RootFS.DiffIDs[i]
+		}
+	}
+
+	var createdBy []string
+	for _, history := range config.History {
+		if !history.EmptyLayer {
+			createdBy = append(createdBy, history.CreatedBy)
+		}
+	}
+	if len(createdBy) == len(layers) {
+		for i, layer := range layers {
+			layer.CreatedBy = createdBy[i]
+		}
+	}
+}
+
+// countingReader tracks how many bytes were read, which is the offset of the next
+// tar entry content once tar.Reader.Next returns, since tar.Reader reads whole blocks
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// layerStream is an uncompressed layer tarball opened for indexing
+type layerStream struct {
+	// reader streams the tarball
+	reader io.Reader
+	// view gives random access to the tarball content
+	view io.ReaderAt
+	// offset returns the offset in view of the content of the current tar entry
+	offset func() int64
+	// closer releases view, if needed
+	closer io.Closer
+}
+
+// anonymousFile creates a temporary file that is unlinked right away, so that it
+// never outlives the scan, even when imgscan crashes
+func anonymousFile() (*os.File, error) {
+	file, err := os.CreateTemp("", "imgscan-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file failed: %v", err)
+	}
+	err = os.Remove(file.Name())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("remove temp file failed: %v", err)
+	}
+	return file, nil
+}
+
+// open returns the uncompressed layer tarball. Uncompressed layers are served from the
+// blob itself and entry contents are skipped with seeks, gzip-compressed layers are
+// decompressed once into an anonymous file while they are indexed, since gzip streams
+// cannot be read from an arbitrary offset
+func (l *Layer) open() (*layerStream, error) {
+	magic := make([]byte, 2)
+	n, err := l.blob.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read layer %s failed: %v", l.Name, err)
+	}
+
+	section := io.NewSectionReader(l.blob, 0, l.size)
+	if !isGzip(magic[:n]) {
+		return &layerStream{
+			reader: section,
+			view:   l.blob,
+			offset: func() int64 {
+				offset, _ := section.Seek(0, io.SeekCurrent)
+				return offset
+			},
+		}, nil
+	}
+
+	gzipReader, err := gzip.NewReader(section)
+	if err != nil {
+		return nil, fmt.Errorf("decompress layer %s failed: %v", l.Name, err)
+	}
+	file, err := anonymousFile()
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{r: io.TeeReader(gzipReader, file)}
+	return &layerStream{
+		reader: counter,
+		view:   file,
+		offset: func() int64 {
+			return counter.n
+		},
+		closer: file,
+	}, nil
+}
+
+// isGzip reports whether head is the beginning of a gzip stream
+func isGzip(head []byte) bool {
+	return bytes.HasPrefix(head, []byte{0x1f, 0x8b})
+}
+
+// isTarball reports whether head, the first block of an entry, is the beginning of a
+// tarball. Docker writes ustar headers, and an empty layer is a bare end-of-archive block
+func isTarball(head []byte) bool {
+	if len(head) < 512 {
+		return false
+	}
+	return bytes.Equal(head[257:262], []byte("ustar")) || bytes.Count(head[:512], []byte{0}) == 512
+}
+
+// keepHeadLength is the length of the beginning of a file passed to a KeepFunc, enough
+// for secrets.IsBinary to tell text from binaries
+const keepHeadLength = 8 << 10
+
+// layerEntry is an entry of a layer tarball indexed from a stream, data holds the content
+// of a regular file when it was kept
+type layerEntry struct {
+	header *tar.Header
+	data   []byte
+	kept   bool
+}
+
+// streamedLayer is a layer tarball of an image archive indexed as it was read from a stream
+type streamedLayer struct {
+	entries []layerEntry
+	// err is why the tarball could not be indexed, it only matters if the manifest lists it
+	err error
+}
+
+// indexStreamedLayer reads a layer tarball, decompressing it on the fly when head shows it is
+// gzip-compressed, and keeps the content of the regular files keep selects, nil keeps them all
+func indexStreamedLayer(reader io.Reader, head []byte, keep KeepFunc) *streamedLayer {
+	if isGzip(head) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return &streamedLayer{err: err}
+		}
+		reader = gzipReader
+	}
+
+	layer := &streamedLayer{}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return layer
+		}
+		if err != nil {
+			return &streamedLayer{err: err}
+		}
+
+		entry := layerEntry{header: header}
+		if name, ok := walkName(header); ok {
+			entry.data, entry.kept, err = readKept(tarReader, name, header.Size, keep)
+			if err != nil {
+				return &streamedLayer{err: err}
+			}
+		}
+		layer.entries = append(layer.entries, entry)
+	}
+}
+
+// readKept returns the content of a file of size bytes if keep selects it
+func readKept(content io.Reader, name string, size int64, keep KeepFunc) ([]byte, bool, error) {
+	head := make([]byte, min(size, keepHeadLength))
+	_, err := io.ReadFull(content, head)
+	if err != nil {
+		return nil, false, err
+	}
+	if keep != nil && !keep(name, size, head) {
+		return nil, false, nil
+	}
+
+	data := bytes.NewBuffer(head)
+	_, err = io.Copy(data, content)
+	if err != nil {
+		return nil, false, err
+	}
+	return data.Bytes(), true, nil
+}
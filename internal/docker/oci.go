@@ -0,0 +1,292 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// supportedLayerMediaTypes lists the layer media types that can be flattened,
+// gzip-compressed layers are decompressed on the fly
+var supportedLayerMediaTypes = map[string]struct{}{
+	"application/vnd.oci.image.layer.v1.tar":                       {},
+	"application/vnd.oci.image.layer.v1.tar+gzip":                  {},
+	"application/vnd.oci.image.layer.nondistributable.v1.tar":      {},
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": {},
+	"application/vnd.docker.image.rootfs.diff.tar":                 {},
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":            {},
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":    {},
+}
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// descriptor references a blob in an OCI image layout
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+type ociIndex struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// parsePlatform parses a platform in the os/arch[/variant] form, an empty string
+// returns nil, no platform was selected
+func parsePlatform(s string) (*platform, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid platform %s, expected os/arch[/variant]", s)
+	}
+	p := &platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p platform) matches(other *platform) bool {
+	if other == nil {
+		return false
+	}
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || p.Variant == other.Variant
+}
+
+func blobPath(layoutDir, digest string) (string, error) {
+	if !digestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("unsupported digest %s", digest)
+	}
+	return filepath.Join(layoutDir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), nil
+}
+
+// verifyBlob checks that the content read from r has the digest of desc, and its size
+// when desc records one, so that a corrupted or tampered layout is never scanned
+func verifyBlob(r io.Reader, desc descriptor) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return fmt.Errorf("read blob %s failed: %v", desc.Digest, err)
+	}
+	if desc.Size != 0 && size != desc.Size {
+		return fmt.Errorf("blob %s holds %d bytes, its descriptor %d", desc.Digest, size, desc.Size)
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != desc.Digest {
+		return fmt.Errorf("blob %s does not match its digest, its content has digest %s", desc.Digest, digest)
+	}
+	return nil
+}
+
+// readBlob returns the content of a blob, checked against its descriptor
+func readBlob(layoutDir string, desc descriptor) ([]byte, error) {
+	path, err := blobPath(layoutDir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s failed: %v", desc.Digest, err)
+	}
+	err = verifyBlob(bytes.NewReader(content), desc)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func readBlobJSON(layoutDir string, desc descriptor, v interface{}) error {
+	content, err := readBlob(layoutDir, desc)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return fmt.Errorf("decode blob %s failed: %v", desc.Digest, err)
+	}
+	return nil
+}
+
+// selectManifest picks the manifest for the wanted platform from an index. A single
+// manifest is used as is when no platform is wanted, several of them need one
+func selectManifest(manifests []descriptor, want *platform) (*descriptor, error) {
+	var candidates []descriptor
+	for _, m := range manifests {
+		// Skip attestations and other artifacts attached to the index by BuildKit
+		if m.Annotations["vnd.docker.reference.type"] != "" {
+			continue
+		}
+		if m.Platform != nil && m.Platform.OS == "unknown" {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no image manifest in index")
+	}
+	if len(candidates) == 1 && (want == nil || candidates[0].Platform == nil) {
+		return &candidates[0], nil
+	}
+
+	var available []string
+	for i, m := range candidates {
+		if want != nil && want.matches(m.Platform) {
+			return &candidates[i], nil
+		}
+		if m.Platform != nil {
+			available = append(available, m.Platform.String())
+		}
+	}
+	if want == nil {
+		return nil, fmt.Errorf("the index lists %d images, select one with --platform, available: %s", len(candidates), strings.Join(available, ", "))
+	}
+	return nil, fmt.Errorf("no manifest for platform %s, available: %s", want, strings.Join(available, ", "))
+}
+
+// resolveOCIManifest follows index.json, and any nested index, down to the image manifest
+func resolveOCIManifest(layoutDir, platformName string) (*ociManifest, error) {
+	want, err := parsePlatform(platformName)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("open index.json failed: %v", err)
+	}
+	var index ociIndex
+	err = json.Unmarshal(content, &index)
+	if err != nil {
+		return nil, fmt.Errorf("decode index.json failed: %v", err)
+	}
+
+	manifests := index.Manifests
+	// Nested indexes are bounded to avoid looping on a malicious layout
+	for depth := 0; depth < 8; depth++ {
+		desc, err := selectManifest(manifests, want)
+		if err != nil {
+			return nil, err
+		}
+
+		switch desc.MediaType {
+		case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+			var nested ociIndex
+			err = readBlobJSON(layoutDir, *desc, &nested)
+			if err != nil {
+				return nil, err
+			}
+			manifests = nested.Manifests
+		case mediaTypeOCIManifest, mediaTypeDockerManifest, "":
+			var manifest ociManifest
+			err = readBlobJSON(layoutDir, *desc, &manifest)
+			if err != nil {
+				return nil, err
+			}
+			return &manifest, nil
+		default:
+			return nil, fmt.Errorf("unsupported manifest media type %s", desc.MediaType)
+		}
+	}
+
+	return nil, fmt.Errorf("too many nested indexes in index.json")
+}
+
+// getLayersFromOCILayout returns the blob paths of the layers of the selected image,
+// each checked against its digest, together with its config when the config blob is
+// readable
+func getLayersFromOCILayout(layoutDir, platformName string) ([]string, *imageConfig, error) {
+	manifest, err := resolveOCIManifest(layoutDir, platformName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var layers []string
+	for _, layer := range manifest.Layers {
+		if _, ok := supportedLayerMediaTypes[layer.MediaType]; !ok {
+			return nil, nil, fmt.Errorf("unsupported layer media type %s", layer.MediaType)
+		}
+		path, err := blobPath(layoutDir, layer.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = verifyLayer(path, layer)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, path)
+	}
+
+	var config imageConfig
+	err = readBlobJSON(layoutDir, manifest.Config, &config)
+	if err != nil {
+		return layers, nil, nil
+	}
+	return layers, &config, nil
+}
+
+// verifyLayer checks a layer blob against its descriptor, reading it from disk once
+func verifyLayer(path string, desc descriptor) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open layer failed: %v", err)
+	}
+	defer file.Close()
+	return verifyBlob(file, desc)
+}
+
+// ReadOCILayoutConfig returns the raw image config of the manifest selected for
+// the given platform in an OCI image layout directory
+func ReadOCILayoutConfig(layoutDir, platformName string) ([]byte, error) {
+	manifest, err := resolveOCIManifest(layoutDir, platformName)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readBlob(layoutDir, manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("read image config failed: %v", err)
+	}
+	return content, nil
+}
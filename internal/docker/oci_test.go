@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBlob stores content in the layout and returns its descriptor
+func writeBlob(t *testing.T, layoutDir, mediaType string, content []byte) descriptor {
+	t.Helper()
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	dir := filepath.Join(layoutDir, "blobs", "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, digest), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return descriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(content))}
+}
+
+func writeJSONBlob(t *testing.T, layoutDir, mediaType string, v interface{}) descriptor {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeBlob(t, layoutDir, mediaType, content)
+}
+
+// writeLayout writes an OCI image layout holding one image of a single layer per
+// platform, a nil platform writes an image without platform information
+func writeLayout(t *testing.T, layer []byte, platforms ...*platform) string {
+	t.Helper()
+	layoutDir := t.TempDir()
+	var index ociIndex
+	for _, p := range platforms {
+		manifest := ociManifest{
+			MediaType: mediaTypeOCIManifest,
+			Config:    writeBlob(t, layoutDir, "application/vnd.oci.image.config.v1+json", []byte(`{}`)),
+			Layers:    []descriptor{writeBlob(t, layoutDir, "application/vnd.oci.image.layer.v1.tar", layer)},
+		}
+		desc := writeJSONBlob(t, layoutDir, mediaTypeOCIManifest, manifest)
+		desc.Platform = p
+		index.Manifests = append(index.Manifests, desc)
+	}
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(layoutDir, "index.json"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return layoutDir
+}
+
+func TestOpenOCILayout(t *testing.T) {
+	layer := buildTar(t, dirEntry("etc/"), fileEntry("etc/motd", "welcome"))
+	amd64 := &platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+	tests := []struct {
+		name      string
+		platforms []*platform
+		platform  string
+		// wantErr is part of the error expected, empty when the layout opens
+		wantErr string
+	}{
+		{"single image", []*platform{nil}, "", ""},
+		{"single image with a platform", []*platform{amd64}, "", ""},
+		{"platform selected", []*platform{amd64, arm64}, "linux/arm64", ""},
+		{"several images", []*platform{amd64, arm64}, "", "the index lists 2 images, select one with --platform, available: linux/amd64, linux/arm64/v8"},
+		{"missing platform", []*platform{amd64}, "linux/s390x", "no manifest for platform linux/s390x"},
+		{"invalid platform", []*platform{amd64}, "linux", "invalid platform linux"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageFS, err := OpenOCILayout(writeLayout(t, layer, tt.platforms...), tt.platform)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OpenOCILayout() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenOCILayout() error = %v", err)
+			}
+			defer imageFS.Close()
+			if got, err := imageFS.ReadFile("etc/motd"); err != nil || string(got) != "welcome" {
+				t.Errorf("ReadFile(etc/motd) = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestOpenOCILayoutDigests(t *testing.T) {
+	layer := buildTar(t, fileEntry("etc/motd", "welcome"))
+	tests := []struct {
+		name string
+		// tamper changes the blob of the layer or the descriptor in the manifest
+		tamper  func(t *testing.T, layoutDir string, layerDesc descriptor)
+		wantErr string
+	}{
+		{
+			name: "layer content replaced",
+			tamper: func(t *testing.T, layoutDir string, layerDesc descriptor) {
+				path, _ := blobPath(layoutDir, layerDesc.Digest)
+				tampered := buildTar(t, fileEntry("etc/motd", "tampered"))
+				if err := os.WriteFile(path, tampered, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "does not match its digest",
+		},
+		{
+			name: "layer truncated",
+			tamper: func(t *testing.T, layoutDir string, layerDesc descriptor) {
+				path, _ := blobPath(layoutDir, layerDesc.Digest)
+				if err := os.Truncate(path, layerDesc.Size-512); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "bytes, its descriptor",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layoutDir := writeLayout(t, layer, nil)
+			manifest, err := resolveOCIManifest(layoutDir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, layoutDir, manifest.Layers[0])
+
+			_, err = OpenOCILayout(layoutDir, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("OpenOCILayout() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A manifest blob is checked too, before its layers are
+	layoutDir := writeLayout(t, layer, nil)
+	content, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := blobPath(layoutDir, index.Manifests[0].Digest)
+	if err := os.WriteFile(path, []byte(`{"layers":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadOCILayoutConfig(layoutDir, ""); err == nil || !strings.Contains(err.Error(), "bytes, its descriptor") {
+		t.Errorf("ReadOCILayoutConfig() of a tampered manifest error = %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// UnsafeEntry is a tarball entry that tries to reach outside of the image root,
+// the entry is refused or neutralized, and it is suspicious in itself
+type UnsafeEntry struct {
+	// Layer is the layer that carries the entry, it is nil for the entries of the image archive itself
+	Layer *Layer
+	// Archive is the name of the image archive carrying the entry when Layer is nil
+	Archive  string
+	Name     string
+	Linkname string
+	Reason   string
+}
+
+// String describes why the entry is unsafe, with the target of a link and, for an
+// entry of the image archive itself, the name of the archive
+func (e UnsafeEntry) String() string {
+	description := e.Reason
+	if e.Linkname != "" {
+		description = fmt.Sprintf("%s, target %s", description, e.Linkname)
+	}
+	if e.Layer == nil {
+		description = fmt.Sprintf("%s, image archive %s", description, e.Archive)
+	}
+	return description
+}
+
+const (
+	reasonPathEscape     = "entry path escapes the image root"
+	reasonHardlinkEscape = "hardlink target escapes the image root"
+	reasonSymlinkEscape  = "symlink target escapes the image root"
+	reasonWriteThrough   = "entry is written through a symlink pointing outside the image root"
+	reasonRootReplace    = "entry replaces the image root"
+)
+
+// safeName cleans the name of a tarball entry relative to the root of the archive,
+// it reports false if the entry escapes the root. Absolute names are kept inside
+// the root, like tar does when extracting them
+func safeName(name string) (string, bool) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return strings.TrimPrefix(path.Join("/", cleaned), "/"), true
+}
+
+// symlinkEscapes reports whether the target of a symlink located in dir climbs
+// above the root of the image when followed lexically
+func symlinkEscapes(dir, linkname string) bool {
+	if path.IsAbs(linkname) {
+		return false
+	}
+	depth := 0
+	for _, part := range strings.Split(path.Clean("/"+dir), "/") {
+		if part != "" {
+			depth++
+		}
+	}
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+func (f *ImageFS) addUnsafe(layer *Layer, header *tar.Header, reason string) {
+	f.UnsafeEntries = append(f.UnsafeEntries, UnsafeEntry{
+		Layer:    layer,
+		Name:     header.Name,
+		Linkname: header.Linkname,
+		Reason:   reason,
+	})
+}
+
+func (f *ImageFS) addUnsafeArchiveEntry(archive string, header *tar.Header) {
+	f.UnsafeEntries = append(f.UnsafeEntries, UnsafeEntry{
+		Archive:  archive,
+		Name:     header.Name,
+		Linkname: header.Linkname,
+		Reason:   reasonPathEscape,
+	})
+}
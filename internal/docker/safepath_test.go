@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"testing"
+)
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"etc/passwd", "etc/passwd", true},
+		{"./etc/passwd", "etc/passwd", true},
+		{"/etc/passwd", "etc/passwd", true},
+		{"etc/", "etc", true},
+		{"a/b/../c", "a/c", true},
+		{".", "", true},
+		{"/", "", true},
+		{"..", "", false},
+		{"../etc/passwd", "", false},
+		{"a/../../etc/passwd", "", false},
+		{"..foo", "..foo", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := safeName(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("safeName(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSymlinkEscapes(t *testing.T) {
+	tests := []struct {
+		dir      string
+		linkname string
+		want     bool
+	}{
+		{"usr/lib", "../share", false},
+		{"usr/lib", "../../etc", false},
+		{"usr/lib", "../../../etc", true},
+		{".", "..", true},
+		{"a", "b/../../..", true},
+		{"a", "b/../..", false},
+		{"var", "/run", false},
+		{"var", "/../../run", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir+"->"+tt.linkname, func(t *testing.T) {
+			if got := symlinkEscapes(tt.dir, tt.linkname); got != tt.want {
+				t.Errorf("symlinkEscapes(%q, %q) = %v, want %v", tt.dir, tt.linkname, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsafeEntryString(t *testing.T) {
+	layer := &Layer{Name: "layer.tar"}
+	tests := []struct {
+		name  string
+		entry UnsafeEntry
+		want  string
+	}{
+		{"layer entry", UnsafeEntry{Layer: layer, Name: "../x", Reason: reasonPathEscape}, reasonPathEscape},
+		{"link", UnsafeEntry{Layer: layer, Name: "up", Linkname: "../..", Reason: reasonSymlinkEscape}, reasonSymlinkEscape + ", target ../.."},
+		{"archive entry", UnsafeEntry{Archive: "image.tar", Name: "../x", Reason: reasonPathEscape}, reasonPathEscape + ", image archive image.tar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
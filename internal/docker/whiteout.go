@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// whiteoutPrefix marks a file or directory deleted from the layers below
+	whiteoutPrefix = ".wh."
+	// whiteoutMetaPrefix is reserved for whiteout metadata that is not part of the image filesystem
+	whiteoutMetaPrefix = ".wh..wh."
+	// whiteoutOpaqueDir hides all lower layer entries of the directory it is placed in
+	whiteoutOpaqueDir = ".wh..wh..opq"
+)
+
+// applyWhiteout applies a whiteout entry of a layer to the layers indexed so far,
+// it reports false if name is not a whiteout entry
+func (f *ImageFS) applyWhiteout(layer *Layer, name string) bool {
+	dir, base := path.Split(name)
+	if !strings.HasPrefix(base, whiteoutPrefix) {
+		return false
+	}
+
+	parent, _, err := f.walk(dir, true, nil)
+	if err != nil || !parent.isDir() {
+		// Nothing below to hide
+		return true
+	}
+
+	switch {
+	case base == whiteoutOpaqueDir:
+		hideLower(parent, layer)
+	case strings.HasPrefix(base, whiteoutMetaPrefix):
+		// Other metadata, such as aufs hardlink directories, is not part of the image filesystem
+	default:
+		// Names such as "." or ".." are never children, so a malformed whiteout cannot delete a parent
+		delete(parent.children, strings.TrimPrefix(base, whiteoutPrefix))
+	}
+	return true
+}
+
+// hideLower removes everything below dir that was not written by layer. Directories
+// are kept when layer wrote them or something below them, but their lower layer
+// content is hidden as well. It reports whether anything written by layer remains
+func hideLower(dir *node, layer *Layer) bool {
+	kept := false
+	for name, child := range dir.children {
+		keep := child.meta.Layer == layer
+		if child.isDir() && hideLower(child, layer) {
+			keep = true
+		}
+		if !keep {
+			delete(dir.children, name)
+			continue
+		}
+		kept = true
+	}
+	return kept
+}
@@ -0,0 +1,361 @@
+package parser
+
+import (
+	"strings"
+)
+
+// expandedCmds are the instructions whose arguments the Dockerfile frontend expands,
+// the others are left to the shell of the build container, which sees ARG and ENV
+// as environment variables but may also define its own
+var expandedCmds = map[string]struct{}{
+	"ADD":        {},
+	"ARG":        {},
+	"COPY":       {},
+	"ENV":        {},
+	"EXPOSE":     {},
+	"FROM":       {},
+	"LABEL":      {},
+	"STOPSIGNAL": {},
+	"USER":       {},
+	"VOLUME":     {},
+	"WORKDIR":    {},
+}
+
+// lexer substitutes variables in words the way the Dockerfile frontend does
+type lexer struct {
+	escape byte
+	lookup func(name string) (string, bool)
+	// keepQuotes leaves quotes and escapes in place, otherwise they are removed
+	keepQuotes bool
+	// keepUnset leaves references to unset variables as written, otherwise they expand to nothing
+	keepUnset bool
+}
+
+// process expands the variables of word, single-quoted text is left alone
+func (l *lexer) process(word string) string {
+	var out strings.Builder
+	inSingle, inDouble := false, false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == l.escape && !inSingle && i+1 < len(word):
+			next := word[i+1]
+			i++
+			// Inside double quotes only the quote, $ and the escape token are escaped
+			if l.keepQuotes || (inDouble && next != '"' && next != '$' && next != l.escape) {
+				out.WriteByte(c)
+			}
+			out.WriteByte(next)
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+			if l.keepQuotes {
+				out.WriteByte(c)
+			}
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+			if l.keepQuotes {
+				out.WriteByte(c)
+			}
+		case c == '$' && !inSingle:
+			value, n := l.variable(word[i+1:])
+			if n == 0 {
+				out.WriteByte(c)
+				continue
+			}
+			out.WriteString(value)
+			i += n
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (!first && '0' <= c && c <= '9')
+}
+
+// variable expands the reference following a $, and returns the number of bytes it
+// spans. 0 means the $ is a literal
+func (l *lexer) variable(rest string) (string, int) {
+	if !strings.HasPrefix(rest, "{") {
+		n := 0
+		for n < len(rest) && isNameChar(rest[n], n == 0) {
+			n++
+		}
+		if n == 0 {
+			return "", 0
+		}
+		name := rest[:n]
+		value, ok := l.lookup(name)
+		if !ok && l.keepUnset {
+			return "$" + name, n
+		}
+		return value, n
+	}
+
+	end := closingBrace(rest)
+	if end < 0 {
+		return "", 0
+	}
+	body := rest[1:end]
+	n := 0
+	for n < len(body) && isNameChar(body[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		return "", 0
+	}
+	name, modifier := body[:n], body[n:]
+	value, ok := l.lookup(name)
+	if !ok && l.keepUnset && modifier == "" {
+		return "${" + body + "}", end + 1
+	}
+
+	switch {
+	case strings.HasPrefix(modifier, ":-"):
+		if value == "" {
+			value = l.process(modifier[2:])
+		}
+	case strings.HasPrefix(modifier, "-"):
+		if !ok {
+			value = l.process(modifier[1:])
+		}
+	case strings.HasPrefix(modifier, ":+"):
+		value = ""
+		if ok && l.lookupNonEmpty(name) {
+			value = l.process(modifier[2:])
+		}
+	case strings.HasPrefix(modifier, "+"):
+		value = ""
+		if ok {
+			value = l.process(modifier[1:])
+		}
+	}
+	// Other modifiers, such as ${VAR:?message}, expand to the value itself
+	return value, end + 1
+}
+
+func (l *lexer) lookupNonEmpty(name string) bool {
+	value, ok := l.lookup(name)
+	return ok && value != ""
+}
+
+// closingBrace returns the index of the brace closing the one rest starts with, or -1
+func closingBrace(rest string) int {
+	depth := 0
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitWords splits text on the whitespace outside of quotes, the words are returned as written
+func splitWords(text string, escape byte) []string {
+	var words []string
+	var word strings.Builder
+	inWord, inSingle, inDouble := false, false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == escape && !inSingle && i+1 < len(text):
+			word.WriteByte(c)
+			word.WriteByte(text[i+1])
+			i++
+			inWord = true
+			continue
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case (c == ' ' || c == '\t') && !inSingle && !inDouble:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteByte(c)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// Assignment is a NAME=value word, such as a variable set by ENV or ARG
+type Assignment struct {
+	Name string
+	// Value has its quotes and escapes removed, Raw is the value as written
+	Value string
+	Raw   string
+}
+
+// Assignments returns the NAME=value words of text, split on the whitespace outside of
+// quotes the way ENV and ARG split them, so a quoted value holding spaces stays whole.
+// Variable references are left as written, text is not part of a Dockerfile, such as
+// the instructions recorded in the history of an image
+func Assignments(text string) []Assignment {
+	l := &lexer{escape: '\\', lookup: func(string) (string, bool) { return "", false }, keepUnset: true}
+	var assignments []Assignment
+	for _, word := range splitWords(text, '\\') {
+		name, raw, ok := strings.Cut(word, "=")
+		if !ok || name == "" {
+			continue
+		}
+		assignments = append(assignments, Assignment{Name: name, Value: l.process(raw), Raw: raw})
+	}
+	return assignments
+}
+
+// variables are the ARG and ENV variables in scope at some point of a Dockerfile
+type variables struct {
+	args map[string]string
+	env  map[string]string
+}
+
+func newVariables() *variables {
+	return &variables{
+		args: make(map[string]string),
+		env:  make(map[string]string),
+	}
+}
+
+// lookup resolves a variable, ENV overrides an ARG of the same name
+func (v *variables) lookup(name string) (string, bool) {
+	if value, ok := v.env[name]; ok {
+		return value, true
+	}
+	value, ok := v.args[name]
+	return value, ok
+}
+
+// Expand returns the text of each instruction with the ARG and ENV variables in
+// scope substituted, following the scoping rules of the Dockerfile frontend: an ARG
+// declared before the first FROM is only visible to FROM lines unless a stage declares
+// it again, ENV is inherited by the stages built on the stage setting it, and
+// buildArgs override the defaults of the ARG they name. Here-documents with a quoted
+// delimiter are not expanded
+func (d *Dockerfile) Expand(buildArgs map[string]string) map[*Instruction]string {
+	expanded := make(map[*Instruction]string)
+	global := newVariables()
+	stageVars := make(map[*Stage]*variables)
+
+	stageOf := make(map[*Instruction]*Stage)
+	for _, stage := range d.Stages() {
+		for _, instruction := range stage.Instructions {
+			stageOf[instruction] = stage
+		}
+	}
+
+	for _, instruction := range d.Instructions {
+		stage := stageOf[instruction]
+		vars := global
+		if stage != nil {
+			if stageVars[stage] == nil {
+				stageVars[stage] = newVariables()
+				if base := stageVars[stage.BaseStage]; stage.BaseStage != nil && base != nil {
+					for name, value := range base.env {
+						stageVars[stage].env[name] = value
+					}
+				}
+			}
+			vars = stageVars[stage]
+		}
+		// FROM lines only see the ARG declared before the first FROM
+		if instruction.Cmd == "FROM" {
+			vars = global
+		}
+
+		expanded[instruction] = d.expandInstruction(instruction, vars)
+
+		switch instruction.Cmd {
+		case "ARG":
+			d.declareArgs(instruction, vars, global, buildArgs, stage == nil)
+		case "ENV":
+			d.setEnv(instruction, vars)
+		}
+	}
+	return expanded
+}
+
+func (d *Dockerfile) expandInstruction(instruction *Instruction, vars *variables) string {
+	_, frontend := expandedCmds[instruction.Cmd]
+	l := &lexer{
+		escape:     d.EscapeToken,
+		lookup:     vars.lookup,
+		keepQuotes: true,
+		keepUnset:  !frontend,
+	}
+
+	line, _, _ := strings.Cut(instruction.Original, "\n")
+	var text strings.Builder
+	text.WriteString(l.process(line))
+	for _, heredoc := range instruction.Heredocs {
+		text.WriteString("\n")
+		if heredoc.Expand {
+			// Only the shell would unquote the content, quotes are plain text to the frontend
+			l.keepUnset = true
+			text.WriteString(l.process(heredoc.Content))
+		} else {
+			text.WriteString(heredoc.Content)
+		}
+		text.WriteString(heredoc.Name)
+	}
+	return text.String()
+}
+
+// declareArgs records the ARG declared by instruction, a stage ARG without a default
+// takes the value of the global ARG of the same name
+func (d *Dockerfile) declareArgs(instruction *Instruction, vars, global *variables, buildArgs map[string]string, isGlobal bool) {
+	l := &lexer{escape: d.EscapeToken, lookup: vars.lookup}
+	for _, word := range splitWords(instruction.Value, d.EscapeToken) {
+		name, defaultValue, hasDefault := strings.Cut(word, "=")
+		if value, ok := buildArgs[name]; ok {
+			vars.args[name] = value
+			continue
+		}
+		switch {
+		case hasDefault:
+			vars.args[name] = l.process(defaultValue)
+		case !isGlobal:
+			if value, ok := global.args[name]; ok {
+				vars.args[name] = value
+			}
+		}
+	}
+}
+
+// setEnv records the variables set by an ENV instruction, in either the
+// ENV KEY=VALUE ... or the legacy ENV KEY VALUE form. The values only see the
+// variables set before the instruction
+func (d *Dockerfile) setEnv(instruction *Instruction, vars *variables) {
+	l := &lexer{escape: d.EscapeToken, lookup: vars.lookup}
+	words := splitWords(instruction.Value, d.EscapeToken)
+	if len(words) == 0 {
+		return
+	}
+	if !strings.Contains(words[0], "=") {
+		value := strings.TrimSpace(strings.TrimPrefix(instruction.Value, words[0]))
+		vars.env[words[0]] = l.process(value)
+		return
+	}
+	env := make(map[string]string)
+	for _, word := range words {
+		name, value, _ := strings.Cut(word, "=")
+		env[name] = l.process(value)
+	}
+	for name, value := range env {
+		vars.env[name] = value
+	}
+}
@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		buildArgs  map[string]string
+		// want maps the index of an instruction to its expanded text
+		want map[int]string
+	}{
+		{
+			name:       "ARG default",
+			dockerfile: "FROM alpine\nARG VERSION=1.2\nCOPY app-$VERSION /app\n",
+			want:       map[int]string{2: "COPY app-1.2 /app"},
+		},
+		{
+			name:       "build arg overrides the default",
+			dockerfile: "FROM alpine\nARG VERSION=1.2\nCOPY app-${VERSION} /app\n",
+			buildArgs:  map[string]string{"VERSION": "2.0"},
+			want:       map[int]string{2: "COPY app-2.0 /app"},
+		},
+		{
+			name:       "global ARG only seen by FROM",
+			dockerfile: "ARG BASE=alpine\nFROM $BASE\nUSER ${BASE}\n",
+			want:       map[int]string{1: "FROM alpine", 2: "USER "},
+		},
+		{
+			name:       "global ARG declared again in the stage",
+			dockerfile: "ARG BASE=alpine\nFROM $BASE\nARG BASE\nUSER $BASE\n",
+			want:       map[int]string{3: "USER alpine"},
+		},
+		{
+			name:       "ENV overrides ARG",
+			dockerfile: "FROM alpine\nARG NAME=arg\nENV NAME=env\nWORKDIR /$NAME\n",
+			want:       map[int]string{3: "WORKDIR /env"},
+		},
+		{
+			name:       "ENV values only see earlier variables",
+			dockerfile: "FROM alpine\nENV A=1 B=$A\nENV C=$B\nUSER $C\n",
+			want:       map[int]string{3: "USER "},
+		},
+		{
+			name:       "legacy ENV form",
+			dockerfile: "FROM alpine\nENV HOME /home/app user\nWORKDIR $HOME\n",
+			want:       map[int]string{2: "WORKDIR /home/app user"},
+		},
+		{
+			name:       "ENV inherited from the base stage",
+			dockerfile: "FROM alpine AS base\nENV APP=/srv\nFROM base\nWORKDIR $APP\nFROM alpine\nWORKDIR $APP\n",
+			want:       map[int]string{3: "WORKDIR /srv", 5: "WORKDIR "},
+		},
+		{
+			name:       "RUN keeps unset variables for the shell",
+			dockerfile: "FROM alpine\nARG TOKEN=abc\nRUN echo $TOKEN $HOME '$TOKEN'\n",
+			want:       map[int]string{2: "RUN echo abc $HOME '$TOKEN'"},
+		},
+		{
+			name:       "modifiers",
+			dockerfile: "FROM alpine\nARG SET=x\nARG EMPTY=\nLABEL a=${UNSET:-def} b=${EMPTY:-def} c=${EMPTY-def} d=${SET:+alt} e=${UNSET+alt}\n",
+			want:       map[int]string{3: "LABEL a=def b=def c= d=alt e="},
+		},
+		{
+			name:       "escaped dollar",
+			dockerfile: "FROM alpine\nARG A=1\nLABEL a=\\$A\n",
+			want:       map[int]string{2: "LABEL a=\\$A"},
+		},
+		{
+			name:       "heredocs",
+			dockerfile: "FROM alpine\nARG TOKEN=abc\nRUN <<EOF\necho $TOKEN\nEOF\nRUN <<'EOF'\necho $TOKEN\nEOF\n",
+			want: map[int]string{
+				2: "RUN <<EOF\necho abc\nEOF",
+				3: "RUN <<'EOF'\necho $TOKEN\nEOF",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parse(t, tt.dockerfile)
+			expanded := d.Expand(tt.buildArgs)
+			for index, want := range tt.want {
+				if got := expanded[d.Instructions[index]]; got != want {
+					t.Errorf("instruction %d expands to %q, want %q", index, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Assignment
+	}{
+		{"A=1 B=2", []Assignment{{"A", "1", "1"}, {"B", "2", "2"}}},
+		{`TOKEN="two words" NEXT=x`, []Assignment{{"TOKEN", "two words", `"two words"`}, {"NEXT", "x", "x"}}},
+		{`PASS='a b'\ c`, []Assignment{{"PASS", `a b c`, `'a b'\ c`}}},
+		{"URL=$HOST/path", []Assignment{{"URL", "$HOST/path", "$HOST/path"}}},
+		{"EMPTY= ignored =x", []Assignment{{"EMPTY", "", ""}}},
+		{"no assignment", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Assignments(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Assignments(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
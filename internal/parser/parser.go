@@ -0,0 +1,335 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const defaultEscapeToken = '\\'
+
+var (
+	directiveRegexp = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.+?)\s*$`)
+	// heredocRegexp matches <<EOF, <<-EOF, <<"EOF" and <<'EOF', but not the <<< here-strings
+	heredocRegexp = regexp.MustCompile(`(^|[^<])<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+)
+
+// knownDirectives are the parser directives BuildKit understands, any other one is a comment
+var knownDirectives = map[string]struct{}{
+	"syntax": {},
+	"escape": {},
+	"check":  {},
+}
+
+// jsonFormCmds are the instructions accepting the JSON form for their arguments
+var jsonFormCmds = map[string]struct{}{
+	"ADD":        {},
+	"CMD":        {},
+	"COPY":       {},
+	"ENTRYPOINT": {},
+	"RUN":        {},
+	"SHELL":      {},
+	"VOLUME":     {},
+}
+
+// heredocCmds are the instructions accepting here-documents
+var heredocCmds = map[string]struct{}{
+	"ADD":  {},
+	"COPY": {},
+	"RUN":  {},
+}
+
+// Range is a span of lines of a Dockerfile, lines are numbered from 1 and End is included
+type Range struct {
+	Start int
+	End   int
+}
+
+// Flag is a --name[=value] flag of an instruction, such as --from=build
+type Flag struct {
+	Name  string
+	Value string
+}
+
+// Heredoc is a here-document of a RUN, COPY or ADD instruction
+type Heredoc struct {
+	// Name is the delimiter word
+	Name string
+	// Content holds the lines between the instruction and the delimiter
+	Content string
+	// Expand reports whether variables are expanded in the content, which is the case unless the delimiter is quoted
+	Expand bool
+	// Chomp reports whether leading tabs are stripped from the content, as requested by <<-
+	Chomp bool
+	Range Range
+}
+
+// Instruction is an instruction of a Dockerfile
+type Instruction struct {
+	// Cmd is the instruction keyword, upper-cased
+	Cmd string
+	// Flags are the flags written before the arguments
+	Flags []Flag
+	// Args are the decoded array of the JSON form, or the words of the shell form
+	Args []string
+	// JSON reports whether the arguments use the JSON form
+	JSON bool
+	// Value is the text of the arguments as written, with line continuations joined
+	Value    string
+	Heredocs []Heredoc
+	// Original is the instruction as written with line continuations joined,
+	// followed by the content of its here-documents
+	Original string
+	Range    Range
+}
+
+// Flag returns the value of the named flag, and whether the instruction has it
+func (i *Instruction) Flag(name string) (string, bool) {
+	for _, flag := range i.Flags {
+		if flag.Name == name {
+			return flag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Comment is a comment line, Text excludes the leading #
+type Comment struct {
+	Text string
+	Line int
+}
+
+// Directive is a parser directive, such as # escape=`
+type Directive struct {
+	Name  string
+	Value string
+	Line  int
+}
+
+// Dockerfile is the syntax tree of a Dockerfile
+type Dockerfile struct {
+	Directives   []Directive
+	Instructions []*Instruction
+	Comments     []Comment
+	// EscapeToken is the line continuation character, set by the escape directive
+	EscapeToken byte
+}
+
+// Directive returns the value of the named parser directive, and whether it is set
+func (d *Dockerfile) Directive(name string) (string, bool) {
+	for _, directive := range d.Directives {
+		if directive.Name == name {
+			return directive.Value, true
+		}
+	}
+	return "", false
+}
+
+// Parse parses a Dockerfile the way BuildKit does: parser directives, line
+// continuations, comments, JSON and shell forms, and here-documents
+func Parse(r io.Reader) (*Dockerfile, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read dockerfile failed: %v", err)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+
+	d := &Dockerfile{EscapeToken: defaultEscapeToken}
+	next, err := d.parseDirectives(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &lineParser{dockerfile: d, lines: lines, next: next}
+	for p.next < len(lines) {
+		trimmed := strings.TrimSpace(lines[p.next])
+		switch {
+		case trimmed == "":
+			p.next++
+		case strings.HasPrefix(trimmed, "#"):
+			p.addComment(trimmed)
+			p.next++
+		default:
+			instruction, err := p.parseInstruction()
+			if err != nil {
+				return nil, err
+			}
+			d.Instructions = append(d.Instructions, instruction)
+		}
+	}
+	return d, nil
+}
+
+// parseDirectives reads the parser directives at the top of the file and returns
+// the index of the first line that is not one
+func (d *Dockerfile) parseDirectives(lines []string) (int, error) {
+	for i, line := range lines {
+		m := directiveRegexp.FindStringSubmatch(line)
+		if m == nil {
+			return i, nil
+		}
+		name := strings.ToLower(m[1])
+		if _, ok := knownDirectives[name]; !ok {
+			return i, nil
+		}
+		if _, ok := d.Directive(name); ok {
+			return 0, fmt.Errorf("line %d: only one %s parser directive can be used", i+1, name)
+		}
+
+		if name == "escape" {
+			if m[2] != "\\" && m[2] != "`" {
+				return 0, fmt.Errorf("line %d: invalid escape token %q, must be \\ or `", i+1, m[2])
+			}
+			d.EscapeToken = m[2][0]
+		}
+		d.Directives = append(d.Directives, Directive{Name: name, Value: m[2], Line: i + 1})
+	}
+	return len(lines), nil
+}
+
+type lineParser struct {
+	dockerfile *Dockerfile
+	lines      []string
+	// next is the index of the next line to parse
+	next int
+}
+
+func (p *lineParser) addComment(trimmed string) {
+	p.dockerfile.Comments = append(p.dockerfile.Comments, Comment{
+		Text: strings.TrimPrefix(trimmed, "#"),
+		Line: p.next + 1,
+	})
+}
+
+// trimContinuation strips the escape token ending a line, and reports whether it was there
+func (p *lineParser) trimContinuation(line string) (string, bool) {
+	trimmed := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(trimmed, string(p.dockerfile.EscapeToken)) {
+		return trimmed[:len(trimmed)-1], true
+	}
+	return line, false
+}
+
+// parseInstruction parses the instruction starting at the next line
+func (p *lineParser) parseInstruction() (*Instruction, error) {
+	start := p.next
+	var logical strings.Builder
+	for {
+		body, continued := p.trimContinuation(p.lines[p.next])
+		logical.WriteString(body)
+		p.next++
+		if !continued {
+			break
+		}
+
+		// Comments and empty lines inside a continued instruction are skipped
+		for p.next < len(p.lines) {
+			trimmed := strings.TrimSpace(p.lines[p.next])
+			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				break
+			}
+			if trimmed != "" {
+				p.addComment(trimmed)
+			}
+			p.next++
+		}
+		if p.next >= len(p.lines) {
+			break
+		}
+	}
+
+	text := strings.TrimSpace(logical.String())
+	keyword, rest, _ := strings.Cut(text, " ")
+	if i := strings.IndexAny(keyword, "\t"); i >= 0 {
+		keyword, rest = keyword[:i], keyword[i+1:]+" "+rest
+	}
+	instruction := &Instruction{
+		Cmd:      strings.ToUpper(keyword),
+		Original: text,
+	}
+	instruction.Flags, rest = parseFlags(strings.TrimSpace(rest))
+	instruction.Value = rest
+
+	if _, ok := jsonFormCmds[instruction.Cmd]; ok && strings.HasPrefix(rest, "[") {
+		var args []string
+		if json.Unmarshal([]byte(rest), &args) == nil {
+			instruction.Args = args
+			instruction.JSON = true
+		}
+	}
+	if !instruction.JSON {
+		instruction.Args = strings.Fields(rest)
+	}
+
+	instruction.Range = Range{Start: start + 1, End: p.next}
+	if _, ok := heredocCmds[instruction.Cmd]; ok && !instruction.JSON {
+		err := p.parseHeredocs(instruction)
+		if err != nil {
+			return nil, err
+		}
+		instruction.Range.End = p.next
+	}
+	return instruction, nil
+}
+
+// parseFlags splits the leading --name[=value] flags from the arguments
+func parseFlags(rest string) ([]Flag, string) {
+	var flags []Flag
+	for strings.HasPrefix(rest, "--") {
+		token, remaining, _ := strings.Cut(rest, " ")
+		if i := strings.IndexAny(token, "\t"); i >= 0 {
+			token, remaining = token[:i], token[i+1:]+" "+remaining
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(token, "--"), "=")
+		flags = append(flags, Flag{Name: name, Value: value})
+		rest = strings.TrimSpace(remaining)
+	}
+	return flags, rest
+}
+
+// parseHeredocs reads the here-documents started by the instruction, in order
+func (p *lineParser) parseHeredocs(instruction *Instruction) error {
+	var original strings.Builder
+	original.WriteString(instruction.Original)
+	for _, m := range heredocRegexp.FindAllStringSubmatch(instruction.Value, -1) {
+		if m[3] != m[5] {
+			continue
+		}
+		heredoc := Heredoc{
+			Name:   m[4],
+			Expand: m[3] == "",
+			Chomp:  m[2] == "-",
+		}
+
+		start := p.next
+		var content strings.Builder
+		terminated := false
+		for p.next < len(p.lines) {
+			line := p.lines[p.next]
+			p.next++
+			if heredoc.Chomp {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == heredoc.Name {
+				terminated = true
+				break
+			}
+			content.WriteString(line)
+			content.WriteString("\n")
+		}
+		if !terminated {
+			return fmt.Errorf("line %d: unterminated heredoc %s", instruction.Range.Start, heredoc.Name)
+		}
+
+		heredoc.Content = content.String()
+		heredoc.Range = Range{Start: start + 1, End: p.next}
+		instruction.Heredocs = append(instruction.Heredocs, heredoc)
+		original.WriteString("\n")
+		original.WriteString(heredoc.Content)
+		original.WriteString(heredoc.Name)
+	}
+	instruction.Original = original.String()
+	return nil
+}
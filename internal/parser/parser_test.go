@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, dockerfile string) *Dockerfile {
+	t.Helper()
+	d, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return d
+}
+
+func TestParseContinuations(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		original   string
+		args       []string
+		lines      Range
+		comments   []Comment
+	}{
+		{
+			name:       "single line",
+			dockerfile: "RUN apt-get update\n",
+			original:   "RUN apt-get update",
+			args:       []string{"apt-get", "update"},
+			lines:      Range{Start: 1, End: 1},
+		},
+		{
+			name:       "continued",
+			dockerfile: "RUN apt-get update \\\n    && apt-get install -y curl\n",
+			original:   "RUN apt-get update     && apt-get install -y curl",
+			args:       []string{"apt-get", "update", "&&", "apt-get", "install", "-y", "curl"},
+			lines:      Range{Start: 1, End: 2},
+		},
+		{
+			name:       "comments and blank lines inside",
+			dockerfile: "RUN apk add \\\n# the client\n\n    curl\n",
+			original:   "RUN apk add     curl",
+			args:       []string{"apk", "add", "curl"},
+			lines:      Range{Start: 1, End: 4},
+			comments:   []Comment{{Text: " the client", Line: 2}},
+		},
+		{
+			name:       "escape directive",
+			dockerfile: "# escape=`\nRUN dir `\n    c:\\\n",
+			original:   "RUN dir     c:\\",
+			args:       []string{"dir", "c:\\"},
+			lines:      Range{Start: 2, End: 3},
+		},
+		{
+			name:       "continuation at end of file",
+			dockerfile: "RUN echo \\",
+			original:   "RUN echo",
+			args:       []string{"echo"},
+			lines:      Range{Start: 1, End: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parse(t, tt.dockerfile)
+			if len(d.Instructions) != 1 {
+				t.Fatalf("got %d instructions, want 1", len(d.Instructions))
+			}
+			instruction := d.Instructions[0]
+			if instruction.Original != tt.original {
+				t.Errorf("Original = %q, want %q", instruction.Original, tt.original)
+			}
+			if !reflect.DeepEqual(instruction.Args, tt.args) {
+				t.Errorf("Args = %q, want %q", instruction.Args, tt.args)
+			}
+			if instruction.Range != tt.lines {
+				t.Errorf("Range = %+v, want %+v", instruction.Range, tt.lines)
+			}
+			if !reflect.DeepEqual(d.Comments, tt.comments) {
+				t.Errorf("Comments = %+v, want %+v", d.Comments, tt.comments)
+			}
+		})
+	}
+}
+
+func TestParseForms(t *testing.T) {
+	d := parse(t, `FROM golang:1.22 AS build
+copy --from=build --chown=app:app /src /dst
+CMD ["/app", "--port", "8080"]
+ENTRYPOINT [not json
+`)
+	if got := len(d.Instructions); got != 4 {
+		t.Fatalf("got %d instructions, want 4", got)
+	}
+
+	copyInstruction := d.Instructions[1]
+	if copyInstruction.Cmd != "COPY" {
+		t.Errorf("Cmd = %q, want COPY", copyInstruction.Cmd)
+	}
+	wantFlags := []Flag{{Name: "from", Value: "build"}, {Name: "chown", Value: "app:app"}}
+	if !reflect.DeepEqual(copyInstruction.Flags, wantFlags) {
+		t.Errorf("Flags = %+v, want %+v", copyInstruction.Flags, wantFlags)
+	}
+	if value, ok := copyInstruction.Flag("chown"); !ok || value != "app:app" {
+		t.Errorf("Flag(chown) = %q, %v", value, ok)
+	}
+	if copyInstruction.Value != "/src /dst" {
+		t.Errorf("Value = %q, want the arguments after the flags", copyInstruction.Value)
+	}
+
+	cmd := d.Instructions[2]
+	if !cmd.JSON || !reflect.DeepEqual(cmd.Args, []string{"/app", "--port", "8080"}) {
+		t.Errorf("CMD JSON = %v, Args = %q", cmd.JSON, cmd.Args)
+	}
+	entrypoint := d.Instructions[3]
+	if entrypoint.JSON {
+		t.Errorf("ENTRYPOINT with invalid JSON parsed as the JSON form")
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	d := parse(t, "# syntax=docker/dockerfile:1\n# escape=`\n# unknown=value\nFROM scratch\n")
+	if value, ok := d.Directive("syntax"); !ok || value != "docker/dockerfile:1" {
+		t.Errorf("Directive(syntax) = %q, %v", value, ok)
+	}
+	if d.EscapeToken != '`' {
+		t.Errorf("EscapeToken = %q, want `", d.EscapeToken)
+	}
+	if len(d.Comments) != 1 || d.Comments[0].Line != 3 {
+		t.Errorf("an unknown directive should be a comment, got %+v", d.Comments)
+	}
+
+	for _, dockerfile := range []string{
+		"# escape=x\nFROM scratch\n",
+		"# escape=`\n# escape=\\\nFROM scratch\n",
+	} {
+		if _, err := Parse(strings.NewReader(dockerfile)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", dockerfile)
+		}
+	}
+}
+
+func TestParseHeredocs(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		heredocs   []Heredoc
+		lines      Range
+		original   string
+	}{
+		{
+			name:       "script",
+			dockerfile: "RUN <<EOF\napt-get update\napt-get install -y curl\nEOF\n",
+			heredocs: []Heredoc{{
+				Name: "EOF", Content: "apt-get update\napt-get install -y curl\n", Expand: true,
+				Range: Range{Start: 2, End: 4},
+			}},
+			lines:    Range{Start: 1, End: 4},
+			original: "RUN <<EOF\napt-get update\napt-get install -y curl\nEOF",
+		},
+		{
+			name:       "quoted delimiter",
+			dockerfile: "RUN <<'EOT' bash\necho $HOME\nEOT\n",
+			heredocs: []Heredoc{{
+				Name: "EOT", Content: "echo $HOME\n", Expand: false,
+				Range: Range{Start: 2, End: 3},
+			}},
+			lines:    Range{Start: 1, End: 3},
+			original: "RUN <<'EOT' bash\necho $HOME\nEOT",
+		},
+		{
+			name:       "chomped tabs",
+			dockerfile: "RUN <<-EOF\n\techo hi\n\tEOF\n",
+			heredocs: []Heredoc{{
+				Name: "EOF", Content: "echo hi\n", Expand: true, Chomp: true,
+				Range: Range{Start: 2, End: 3},
+			}},
+			lines:    Range{Start: 1, End: 3},
+			original: "RUN <<-EOF\necho hi\nEOF",
+		},
+		{
+			name:       "several files",
+			dockerfile: "COPY <<FILE1 <<FILE2 /etc/\none\nFILE1\ntwo\nFILE2\n",
+			heredocs: []Heredoc{
+				{Name: "FILE1", Content: "one\n", Expand: true, Range: Range{Start: 2, End: 3}},
+				{Name: "FILE2", Content: "two\n", Expand: true, Range: Range{Start: 4, End: 5}},
+			},
+			lines:    Range{Start: 1, End: 5},
+			original: "COPY <<FILE1 <<FILE2 /etc/\none\nFILE1\ntwo\nFILE2",
+		},
+		{
+			name:       "here-string",
+			dockerfile: "RUN cat <<<EOF\n",
+			lines:      Range{Start: 1, End: 1},
+			original:   "RUN cat <<<EOF",
+		},
+		{
+			name:       "JSON form",
+			dockerfile: `RUN ["cat", "<<EOF"]` + "\n",
+			lines:      Range{Start: 1, End: 1},
+			original:   `RUN ["cat", "<<EOF"]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parse(t, tt.dockerfile)
+			if len(d.Instructions) != 1 {
+				t.Fatalf("got %d instructions, want 1", len(d.Instructions))
+			}
+			instruction := d.Instructions[0]
+			if !reflect.DeepEqual(instruction.Heredocs, tt.heredocs) {
+				t.Errorf("Heredocs = %+v, want %+v", instruction.Heredocs, tt.heredocs)
+			}
+			if instruction.Range != tt.lines {
+				t.Errorf("Range = %+v, want %+v", instruction.Range, tt.lines)
+			}
+			if instruction.Original != tt.original {
+				t.Errorf("Original = %q, want %q", instruction.Original, tt.original)
+			}
+		})
+	}
+
+	d := parse(t, "FROM alpine\nRUN <<EOF\necho hi\nEOF\nUSER app\n")
+	if got := d.Instructions[2]; got.Cmd != "USER" || got.Range.Start != 5 {
+		t.Errorf("instruction after a heredoc = %s at %d, want USER at 5", got.Cmd, got.Range.Start)
+	}
+	if _, err := Parse(strings.NewReader("RUN <<EOF\necho hi\n")); err == nil {
+		t.Errorf("Parse() of an unterminated heredoc succeeded, want an error")
+	}
+}
@@ -0,0 +1,461 @@
+package parser
+
+import (
+	"path"
+	"strings"
+)
+
+// shells are the commands running the script given with -c or on their standard input
+var shells = map[string]struct{}{
+	"ash":  {},
+	"bash": {},
+	"dash": {},
+	"ksh":  {},
+	"mksh": {},
+	"sh":   {},
+	"zsh":  {},
+}
+
+// reservedWords are the shell keywords that may start a command, they are skipped
+// to reach the command itself
+var reservedWords = map[string]struct{}{
+	"!":     {},
+	"{":     {},
+	"}":     {},
+	"if":    {},
+	"then":  {},
+	"elif":  {},
+	"else":  {},
+	"fi":    {},
+	"while": {},
+	"until": {},
+	"do":    {},
+	"done":  {},
+	"esac":  {},
+	"time":  {},
+}
+
+// compoundWords start a command that runs no program, such as for f in a b
+var compoundWords = map[string]struct{}{
+	"case":     {},
+	"for":      {},
+	"function": {},
+	"select":   {},
+}
+
+// wrapperOptions are the commands running the command given in their arguments,
+// with their options taking a value
+var wrapperOptions = map[string]map[string]bool{
+	"command": {},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "-S": true},
+	"exec":    {"-a": true},
+	"nohup":   {},
+	"sudo":    {"-u": true, "-g": true, "-h": true, "-p": true, "-C": true, "-D": true, "-r": true, "-t": true, "-T": true, "-U": true},
+}
+
+// Command is a simple command of a shell script, such as apt-get install -y curl
+type Command struct {
+	// Args are the words of the command after quote removal, Args[0] names the program.
+	// The variable assignments written before the program are left out
+	Args []string
+	// Wrapped is the command run by a wrapper such as sudo or env, nil for other commands
+	Wrapped *Command
+	// PipedTo is the next command of the pipeline, reading the output of this one
+	PipedTo *Command
+	// heredocs are the delimiters of the here-documents redirected to the command
+	heredocs []string
+}
+
+// Name returns the name of the program run by the command, without its directory
+func (c *Command) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return path.Base(c.Args[0])
+}
+
+// String returns the name of the program followed by its arguments, separated by spaces
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.Name()}, c.Args[1:]...), " ")
+}
+
+// ParseShell splits a shell script into its simple commands, in the order they are
+// written. Lists (&&, ||, ;, &), pipelines, subshells, groups and the compound commands
+// are flattened, and the commands of $(...) and `...` substitutions and of the scripts
+// run with sh -c are listed too. The script is not run, so commands are listed whether
+// or not a condition would run them
+func ParseShell(script string) []*Command {
+	var commands []*Command
+	for _, command := range parseShell(script) {
+		if len(command.Args) > 0 {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+func parseShell(script string) []*Command {
+	p := &shellParser{script: script}
+	p.parse()
+	return p.commands
+}
+
+// Commands returns the simple commands run by a RUN instruction, parsed from its shell
+// form, from the script of its JSON form when that runs a shell with -c, and from its
+// here-documents run by a shell. Other instructions run no command
+func (i *Instruction) Commands() []*Command {
+	if i.Cmd != "RUN" {
+		return nil
+	}
+	if i.JSON {
+		if len(i.Args) == 0 {
+			return nil
+		}
+		if script, ok := shellScript(i.Args); ok {
+			return ParseShell(script)
+		}
+		command := &Command{Args: append([]string{}, i.Args...)}
+		command.unwrap()
+		return []*Command{command}
+	}
+
+	var commands []*Command
+	for _, command := range parseShell(i.Value) {
+		if len(command.Args) > 0 {
+			commands = append(commands, command)
+			// Only a shell reading its standard input runs the here-documents as a script
+			if _, shell := shells[command.Name()]; !shell {
+				continue
+			}
+			if _, ok := shellScript(command.Args); ok {
+				continue
+			}
+		}
+		// RUN <<EOF runs the here-document with the default shell
+		for _, name := range command.heredocs {
+			for _, heredoc := range i.Heredocs {
+				if heredoc.Name == name {
+					commands = append(commands, ParseShell(heredoc.Content)...)
+				}
+			}
+		}
+	}
+	return commands
+}
+
+// shellScript returns the script of a command running a shell with -c, such as sh -ec "..."
+func shellScript(args []string) (string, bool) {
+	if _, ok := shells[path.Base(args[0])]; !ok {
+		return "", false
+	}
+	for i := 1; i < len(args)-1; i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
+			return "", false
+		}
+		if strings.Contains(arg, "c") {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// unwrap sets Wrapped when the command is a wrapper running another command
+func (c *Command) unwrap() {
+	options, ok := wrapperOptions[c.Name()]
+	if !ok {
+		return
+	}
+	args := c.Args[1:]
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			args = args[1:]
+			break
+		}
+		if c.Name() == "command" && (arg == "-v" || arg == "-V") {
+			// command -v looks a program up without running it
+			return
+		}
+		if strings.HasPrefix(arg, "-") {
+			args = args[1:]
+			if options[arg] && len(args) > 0 {
+				args = args[1:]
+			}
+			continue
+		}
+		if c.Name() == "env" && isAssignment(arg) {
+			args = args[1:]
+			continue
+		}
+		break
+	}
+	if len(args) == 0 {
+		return
+	}
+	c.Wrapped = &Command{Args: args}
+	c.Wrapped.unwrap()
+}
+
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && (i == 0 || !(r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// shellParser splits a script into commands, word by word
+type shellParser struct {
+	script   string
+	pos      int
+	commands []*Command
+	// words and heredocs belong to the command being read
+	words    []string
+	heredocs []string
+	// previous is the command piping its output into the command being read
+	previous *Command
+}
+
+func (p *shellParser) parse() {
+	for p.pos < len(p.script) {
+		c := p.script[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\\' && p.pos+1 < len(p.script) && p.script[p.pos+1] == '\n':
+			p.pos += 2
+		case c == '#':
+			for p.pos < len(p.script) && p.script[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '|':
+			if p.peek("||") {
+				p.pos += 2
+				p.end(false)
+				continue
+			}
+			p.pos++
+			if p.peek("&") {
+				p.pos++
+			}
+			p.end(true)
+		case c == '\n' || c == ';' || c == '&' || c == '(' || c == ')':
+			if c == '&' && p.peek("&>") {
+				p.redirect()
+				continue
+			}
+			p.pos++
+			p.end(false)
+		case c == '<' || c == '>':
+			p.redirect()
+		default:
+			word := p.word()
+			if p.pos < len(p.script) && (p.script[p.pos] == '<' || p.script[p.pos] == '>') && isDigits(word) {
+				// The file descriptor of a redirection, such as 2>&1
+				p.redirect()
+				continue
+			}
+			p.words = append(p.words, word)
+		}
+	}
+	p.end(false)
+}
+
+func (p *shellParser) peek(s string) bool {
+	return strings.HasPrefix(p.script[p.pos:], s)
+}
+
+func isDigits(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// redirect skips a redirection operator and the word it applies to, and records
+// the delimiters of here-documents
+func (p *shellParser) redirect() {
+	heredoc := false
+	switch {
+	case p.peek("<<<"):
+		p.pos += 3
+	case p.peek("<<-"):
+		p.pos += 3
+		heredoc = true
+	case p.peek("<<"):
+		p.pos += 2
+		heredoc = true
+	default:
+		for p.pos < len(p.script) && strings.IndexByte("<>&|", p.script[p.pos]) >= 0 {
+			p.pos++
+		}
+	}
+	for p.pos < len(p.script) && (p.script[p.pos] == ' ' || p.script[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos >= len(p.script) || strings.IndexByte("\n;&|()<>", p.script[p.pos]) >= 0 {
+		return
+	}
+	target := p.word()
+	if heredoc {
+		p.heredocs = append(p.heredocs, target)
+	}
+}
+
+// word reads a word and removes its quotes, substitutions are kept as written
+// and their commands are parsed on their own
+func (p *shellParser) word() string {
+	var word strings.Builder
+	quoted := false
+	for p.pos < len(p.script) {
+		c := p.script[p.pos]
+		if !quoted && strings.IndexByte(" \t\r\n;&|()<>", c) >= 0 {
+			break
+		}
+		switch {
+		case c == '\\' && p.pos+1 < len(p.script):
+			next := p.script[p.pos+1]
+			p.pos += 2
+			if next == '\n' {
+				continue
+			}
+			if quoted && strings.IndexByte("$`\"\\", next) < 0 {
+				word.WriteByte(c)
+			}
+			word.WriteByte(next)
+		case c == '\'' && !quoted:
+			end := strings.IndexByte(p.script[p.pos+1:], '\'')
+			if end < 0 {
+				word.WriteString(p.script[p.pos+1:])
+				p.pos = len(p.script)
+				continue
+			}
+			word.WriteString(p.script[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+		case c == '"':
+			quoted = !quoted
+			p.pos++
+		case c == '$' && p.peek("$(("):
+			// Arithmetic expansion runs no command
+			end := strings.Index(p.script[p.pos:], "))")
+			if end < 0 {
+				end = len(p.script) - p.pos - 2
+			}
+			word.WriteString(p.script[p.pos : p.pos+end+2])
+			p.pos += end + 2
+		case c == '$' && p.peek("$("):
+			word.WriteString(p.substitution(p.pos+2, ')'))
+		case c == '$' && p.peek("${"):
+			end := strings.IndexByte(p.script[p.pos:], '}')
+			if end < 0 {
+				end = len(p.script) - p.pos - 1
+			}
+			word.WriteString(p.script[p.pos : p.pos+end+1])
+			p.pos += end + 1
+		case c == '`':
+			word.WriteString(p.substitution(p.pos+1, '`'))
+		default:
+			word.WriteByte(c)
+			p.pos++
+		}
+	}
+	return word.String()
+}
+
+// substitution reads a command substitution whose script starts at start and ends
+// with close, parses its commands and returns the substitution as written
+func (p *shellParser) substitution(start int, close byte) string {
+	depth := 0
+	end := start
+	for end < len(p.script) {
+		c := p.script[end]
+		if c == '\\' {
+			end += 2
+			continue
+		}
+		if c == '\'' && close == ')' {
+			if quote := strings.IndexByte(p.script[end+1:], '\''); quote >= 0 {
+				end += quote + 2
+				continue
+			}
+		}
+		if c == close && depth == 0 {
+			break
+		}
+		if close == ')' && c == '(' {
+			depth++
+		} else if close == ')' && c == ')' {
+			depth--
+		}
+		end++
+	}
+	if end > len(p.script) {
+		end = len(p.script)
+	}
+
+	p.commands = append(p.commands, parseShell(p.script[start:end])...)
+	written := p.script[p.pos:min(end+1, len(p.script))]
+	p.pos = min(end+1, len(p.script))
+	return written
+}
+
+// end completes the command being read, piped is set when its output is piped into the next one
+func (p *shellParser) end(piped bool) {
+	words, heredocs := p.words, p.heredocs
+	p.words, p.heredocs = nil, nil
+
+	for len(words) > 0 && isAssignment(words[0]) {
+		words = words[1:]
+	}
+	for len(words) > 0 {
+		if _, ok := reservedWords[words[0]]; !ok {
+			break
+		}
+		words = words[1:]
+	}
+	if len(words) > 0 {
+		if _, ok := compoundWords[words[0]]; ok {
+			words = nil
+		}
+	}
+	if len(words) == 0 && len(heredocs) == 0 {
+		if !piped {
+			p.previous = nil
+		}
+		return
+	}
+
+	command := &Command{Args: words, heredocs: heredocs}
+	if len(words) > 0 {
+		command.unwrap()
+	}
+	if p.previous != nil {
+		p.previous.PipedTo = command
+	}
+	p.previous = nil
+	if piped {
+		p.previous = command
+	}
+	p.commands = append(p.commands, command)
+
+	// The script of sh -c is run as well
+	for run := command; run != nil; run = run.Wrapped {
+		if len(run.Args) > 0 {
+			if script, ok := shellScript(run.Args); ok {
+				p.commands = append(p.commands, parseShell(script)...)
+			}
+		}
+	}
+}
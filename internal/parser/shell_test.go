@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func commandStrings(commands []*Command) []string {
+	var strs []string
+	for _, command := range commands {
+		strs = append(strs, command.String())
+	}
+	return strs
+}
+
+func TestParseShell(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"lists", "apt-get update && apt-get install -y curl || true; rm -rf /tmp & wait", []string{
+			"apt-get update", "apt-get install -y curl", "true", "rm -rf /tmp", "wait",
+		}},
+		{"quotes", `echo "a b" 'c $d' e\ f "g\"h"`, []string{`echo a b c $d e f g"h`}},
+		{"assignments before the program", "DEBIAN_FRONTEND=noninteractive apt-get install -y git", []string{"apt-get install -y git"}},
+		{"directory of the program", "/usr/bin/curl -fsSL https://x", []string{"curl -fsSL https://x"}},
+		{"redirections", "curl https://x > /tmp/out 2>&1 < /dev/null", []string{"curl https://x"}},
+		{"comments and continuations", "echo a \\\n  b # echo c\necho d", []string{"echo a b", "echo d"}},
+		{"subshells and groups", "(cd /src && make) ; { echo done; }", []string{"cd /src", "make", "echo done"}},
+		{"compound commands", "if [ -f x ]; then rm x; fi; for f in a b; do echo $f; done", []string{
+			"[ -f x ]", "rm x", "echo $f",
+		}},
+		{"command substitutions", "tar xf $(curl -s https://x | tail -1) `id -u`", []string{
+			"curl -s https://x", "tail -1", "id -u", "tar xf $(curl -s https://x | tail -1) `id -u`",
+		}},
+		{"arithmetic", "echo $((1 + 2))", []string{"echo $((1 + 2))"}},
+		{"sh -c", `sh -ec "wget -qO- https://x | sh"`, []string{"sh -ec wget -qO- https://x | sh", "wget -qO- https://x", "sh"}},
+		{"wrapper running sh -c", `sudo -u app bash -c "chmod 777 /srv"`, []string{"sudo -u app bash -c chmod 777 /srv", "chmod 777 /srv"}},
+		{"empty", "  \n ; ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandStrings(ParseShell(tt.script)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseShell(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseShellPipes(t *testing.T) {
+	commands := ParseShell("curl -fsSL https://x | tee /tmp/log |& bash -s -- --yes && echo ok | cat")
+	if len(commands) != 5 {
+		t.Fatalf("got %d commands, want 5: %q", len(commands), commandStrings(commands))
+	}
+	curl, tee, bash, echo, cat := commands[0], commands[1], commands[2], commands[3], commands[4]
+	if curl.PipedTo != tee || tee.PipedTo != bash || echo.PipedTo != cat {
+		t.Errorf("pipelines not linked: %q", commandStrings(commands))
+	}
+	if bash.PipedTo != nil || cat.PipedTo != nil {
+		t.Errorf("the last command of a pipeline is piped to another")
+	}
+}
+
+func TestParseShellWrappers(t *testing.T) {
+	tests := []struct {
+		script  string
+		wrapped []string
+	}{
+		{"sudo -u root -E apt-get install -y curl", []string{"sudo", "apt-get"}},
+		{"env -u HOME PATH=/bin FOO=1 make install", []string{"env", "make"}},
+		{"sudo env A=1 nohup ./server", []string{"sudo", "env", "nohup", "server"}},
+		{"exec -a name -- /app", []string{"exec", "app"}},
+		{"command -v curl", []string{"command"}},
+		{"sudo", []string{"sudo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			commands := ParseShell(tt.script)
+			if len(commands) != 1 {
+				t.Fatalf("got %d commands, want 1", len(commands))
+			}
+			var names []string
+			for command := commands[0]; command != nil; command = command.Wrapped {
+				names = append(names, command.Name())
+			}
+			if !reflect.DeepEqual(names, tt.wrapped) {
+				t.Errorf("wrapped commands = %q, want %q", names, tt.wrapped)
+			}
+		})
+	}
+}
+
+func TestInstructionCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []string
+	}{
+		{"shell form", "RUN apt-get update && apt-get install -y curl", []string{"apt-get update", "apt-get install -y curl"}},
+		{"JSON form", `RUN ["/usr/bin/apt-get", "install", "-y", "curl"]`, []string{"apt-get install -y curl"}},
+		{"JSON form running a shell", `RUN ["/bin/sh", "-c", "chmod 777 /srv; echo ok"]`, []string{"chmod 777 /srv", "echo ok"}},
+		{"JSON form with a wrapper", `RUN ["sudo", "chmod", "777", "/srv"]`, []string{"sudo chmod 777 /srv"}},
+		{"heredoc script", "RUN <<EOF\nset -e\ncurl https://x | sh\nEOF", []string{"set -e", "curl https://x", "sh"}},
+		{"heredoc read by a shell", "RUN python3 <<EOF && bash <<SCRIPT\nimport os\nEOF\nrm -rf /tmp\nSCRIPT", []string{"python3", "bash", "rm -rf /tmp"}},
+		{"heredoc of a file", "RUN cat <<EOF > /etc/motd\nrm -rf /\nEOF", []string{"cat"}},
+		{"other instruction", "CMD curl https://x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parse(t, tt.dockerfile)
+			if got := commandStrings(d.Instructions[0].Commands()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Commands() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stage is a build stage, started by a FROM instruction
+type Stage struct {
+	// Name is the name given by FROM ... AS name, empty for an unnamed stage
+	Name string
+	// Index is the position of the stage in the Dockerfile, from 0
+	Index int
+	// BaseName is the image or the stage the stage is built from
+	BaseName string
+	// BaseStage is the earlier stage the stage is built from, nil when it is built from an image
+	BaseStage *Stage
+	// Dependencies are the earlier stages the stage copies or mounts files from,
+	// with COPY --from or RUN --mount=from
+	Dependencies []*Stage
+	// Instructions are the instructions of the stage, starting with its FROM instruction
+	Instructions []*Instruction
+}
+
+// String returns the name of the stage, or its index when it is unnamed,
+// which is how other instructions refer to it
+func (s *Stage) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(s.Index)
+}
+
+// Stages splits the instructions into build stages, the instructions before the
+// first FROM are not part of any stage
+func (d *Dockerfile) Stages() []*Stage {
+	var stages []*Stage
+	var current *Stage
+	for _, instruction := range d.Instructions {
+		if instruction.Cmd == "FROM" {
+			current = &Stage{Index: len(stages)}
+			if len(instruction.Args) > 0 {
+				current.BaseName = instruction.Args[0]
+			}
+			if len(instruction.Args) >= 3 && strings.EqualFold(instruction.Args[1], "AS") {
+				current.Name = strings.ToLower(instruction.Args[2])
+			}
+			for _, stage := range stages {
+				if stage.Name != "" && stage.Name == strings.ToLower(current.BaseName) {
+					current.BaseStage = stage
+				}
+			}
+			stages = append(stages, current)
+		}
+		if current == nil {
+			continue
+		}
+		current.Instructions = append(current.Instructions, instruction)
+
+		for _, ref := range stageRefs(instruction) {
+			if dependency := findStage(stages[:current.Index], ref); dependency != nil {
+				current.Dependencies = append(current.Dependencies, dependency)
+			}
+		}
+	}
+	return stages
+}
+
+// stageRefs returns the stages or images an instruction copies or mounts files from
+func stageRefs(instruction *Instruction) []string {
+	var refs []string
+	for _, flag := range instruction.Flags {
+		switch {
+		case instruction.Cmd == "COPY" && flag.Name == "from":
+			refs = append(refs, flag.Value)
+		case instruction.Cmd == "RUN" && flag.Name == "mount":
+			for _, option := range strings.Split(flag.Value, ",") {
+				if key, value, _ := strings.Cut(option, "="); key == "from" {
+					refs = append(refs, value)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// findStage returns the stage ref names or indexes, or nil when ref is an image
+func findStage(stages []*Stage, ref string) *Stage {
+	ref = strings.ToLower(ref)
+	for _, stage := range stages {
+		if stage.Name != "" && stage.Name == ref {
+			return stage
+		}
+	}
+	if index, err := strconv.Atoi(ref); err == nil && index >= 0 && index < len(stages) {
+		return stages[index]
+	}
+	return nil
+}
+
+// Target returns the stage a build of the Dockerfile produces: the stage named
+// target, or the last stage when target is empty
+func Target(stages []*Stage, target string) (*Stage, error) {
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no build stage found")
+	}
+	if target == "" {
+		return stages[len(stages)-1], nil
+	}
+	for _, stage := range stages {
+		if stage.Name != "" && stage.Name == strings.ToLower(target) {
+			return stage, nil
+		}
+	}
+	return nil, fmt.Errorf("target stage %s not found", target)
+}
+
+// Reachable returns the stages a build of target runs: target itself and the
+// stages it is built on, copies from or mounts, recursively
+func Reachable(target *Stage) map[*Stage]bool {
+	reachable := make(map[*Stage]bool)
+	pending := []*Stage{target}
+	for len(pending) > 0 {
+		stage := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if stage == nil || reachable[stage] {
+			continue
+		}
+		reachable[stage] = true
+		pending = append(pending, stage.BaseStage)
+		pending = append(pending, stage.Dependencies...)
+	}
+	return reachable
+}
@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	d := parse(t, `ARG BASE=alpine
+FROM golang AS build
+FROM alpine AS assets
+FROM build AS test
+FROM $BASE
+COPY --from=build /app /app
+RUN --mount=type=cache,target=/root --mount=from=1,target=/assets true
+`)
+	stages := d.Stages()
+	if len(stages) != 4 {
+		t.Fatalf("got %d stages, want 4", len(stages))
+	}
+	build, assets, test, final := stages[0], stages[1], stages[2], stages[3]
+	if test.BaseStage != build || final.BaseStage != nil {
+		t.Errorf("base stages = %v, %v", test.BaseStage, final.BaseStage)
+	}
+	if !reflect.DeepEqual(final.Dependencies, []*Stage{build, assets}) {
+		t.Errorf("Dependencies = %v, want [build assets]", final.Dependencies)
+	}
+	if final.String() != "3" || build.String() != "build" {
+		t.Errorf("String() = %q, %q", final.String(), build.String())
+	}
+
+	target, err := Target(stages, "")
+	if err != nil || target != final {
+		t.Errorf("Target() = %v, %v, want the last stage", target, err)
+	}
+	reachable := Reachable(target)
+	if !reachable[build] || !reachable[assets] || reachable[test] {
+		t.Errorf("Reachable() = %v, want build, assets and the last stage", reachable)
+	}
+	if target, err = Target(stages, "TEST"); err != nil || target != test {
+		t.Errorf("Target(TEST) = %v, %v", target, err)
+	}
+	if _, err = Target(stages, "missing"); err == nil {
+		t.Errorf("Target(missing) succeeded, want an error")
+	}
+}
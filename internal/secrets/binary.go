@@ -0,0 +1,39 @@
+package secrets
+
+import "bytes"
+
+// binaryMagics are the leading bytes of the binary formats found in images: executables
+// and libraries, archives and compressed files, images, documents and databases
+var binaryMagics = [][]byte{
+	[]byte("\x7fELF"),
+	[]byte("MZ"),
+	[]byte("\xfe\xed\xfa\xce"), []byte("\xfe\xed\xfa\xcf"), []byte("\xce\xfa\xed\xfe"), []byte("\xcf\xfa\xed\xfe"),
+	[]byte("\xca\xfe\xba\xbe"),
+	[]byte("\x00asm"),
+	[]byte("!<arch>\n"),
+	[]byte("\x1f\x8b"),
+	[]byte("BZh"),
+	[]byte("\xfd7zXZ\x00"),
+	[]byte("\x28\xb5\x2f\xfd"),
+	[]byte("PK\x03\x04"),
+	[]byte("7z\xbc\xaf\x27\x1c"),
+	[]byte("\x89PNG"),
+	[]byte("\xff\xd8\xff"),
+	[]byte("GIF8"),
+	[]byte("%PDF"),
+	[]byte("SQLite format 3\x00"),
+}
+
+// binarySniffLength is the length of the beginning of a file searched for a NUL byte
+const binarySniffLength = 8000
+
+// IsBinary reports whether content is not text, telling by the magic bytes of a known
+// binary format at its beginning, or by a NUL byte in its first 8000 bytes
+func IsBinary(content []byte) bool {
+	for _, magic := range binaryMagics {
+		if bytes.HasPrefix(content, magic) {
+			return true
+		}
+	}
+	return bytes.IndexByte(content[:min(len(content), binarySniffLength)], 0) >= 0
+}
@@ -0,0 +1,171 @@
+package secrets
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MaxCredentialFileSize bounds the content read from a file to recognize credentials,
+// credential files are small and larger files are skipped
+const MaxCredentialFileSize = 1 << 20
+
+// CredentialFile is a kind of file that holds credential material
+type CredentialFile struct {
+	// ID identifies the kind of credential in reports
+	ID          string
+	Description string
+	// Paths are the path suffixes the file is known by, matched on whole path components,
+	// they may hold the wildcards of path.Match such as .ssh/id_*. A file is recognized
+	// by its content alone when Paths and Extensions are empty
+	Paths []string
+	// Extensions are the file name extensions the file is known by, such as .p12
+	Extensions []string
+	// Content must match the content of the file when set
+	Content *regexp.Regexp
+	// Verify must accept the content of the file when set, parsing its format
+	Verify func(content []byte) bool
+}
+
+// privateKeyRegexp matches the header of a PEM private key
+var privateKeyRegexp = regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)
+
+// CredentialFiles is the catalog of credential files recognized by MatchCredentialFile
+var CredentialFiles = []*CredentialFile{
+	// A service account key holds a private key, it is recognized first
+	{
+		ID:          "gcp-service-account",
+		Description: "GCP service account key",
+		Content:     regexp.MustCompile(`"type"\s*:\s*"service_account"`),
+		Verify: func(content []byte) bool {
+			return VerifyGCPServiceAccount(string(content))
+		},
+	},
+	{
+		ID:          "ssh-private-key",
+		Description: "SSH private key",
+		Paths:       []string{".ssh/id_*"},
+		Content:     privateKeyRegexp,
+	},
+	{
+		ID:          "private-key",
+		Description: "private key",
+		Content:     privateKeyRegexp,
+	},
+	{
+		ID:          "npmrc",
+		Description: "npm registry auth token",
+		Paths:       []string{".npmrc"},
+		Content:     regexp.MustCompile(`(?m)^\s*[^#;\s]*(_authToken|_auth|_password)\s*=\s*\S`),
+	},
+	{
+		ID:          "aws-credentials",
+		Description: "AWS shared credentials",
+		Paths:       []string{".aws/credentials"},
+		Content:     regexp.MustCompile(`(?mi)^\s*aws_(secret_access_key|session_token)\s*=\s*\S`),
+	},
+	{
+		ID:          "docker-config",
+		Description: "Docker registry credentials",
+		Paths:       []string{".docker/config.json"},
+		Content:     regexp.MustCompile(`"(auth|identitytoken)"\s*:\s*"[^"]+"`),
+	},
+	{
+		ID:          "pkcs12",
+		Description: "PKCS#12 keystore",
+		Extensions:  []string{".p12", ".pfx"},
+		Verify:      VerifyPKCS12,
+	},
+	{
+		ID:          "kubeconfig",
+		Description: "Kubernetes cluster credentials",
+		Paths:       []string{".kube/config"},
+		Content:     regexp.MustCompile(`(?m)^\s*(client-key-data|token|password)\s*:\s*\S`),
+	},
+	{
+		ID:          "dotenv",
+		Description: "environment file holding secrets",
+		Paths:       []string{".env", ".env.*"},
+		Content:     regexp.MustCompile(`(?mi)^\s*(export\s+)?[a-z0-9_]*(passwd|password|pwd|secret|token|api_?key|private_?key|access_?key)[a-z0-9_]*\s*=\s*["']?[^\s"'#$]`),
+	},
+	{
+		ID:          "wp-config",
+		Description: "WordPress database password and keys",
+		Paths:       []string{"wp-config.php"},
+		Content:     regexp.MustCompile(`define\(\s*['"](DB_PASSWORD|AUTH_KEY|SECURE_AUTH_KEY|LOGGED_IN_KEY|NONCE_KEY)['"]\s*,\s*['"][^'"]+['"]`),
+	},
+	{
+		ID:          "git-credentials",
+		Description: "git credential store",
+		Paths:       []string{".git-credentials"},
+		Content:     regexp.MustCompile(`(?m)^\s*https?://[^:/\s]+:[^@\s]+@`),
+	},
+}
+
+// matchPath reports whether name ends with suffix on a path component boundary, the
+// wildcards of suffix match within a path component
+func matchPath(name, suffix string) bool {
+	name = path.Clean("/" + name)
+	if !strings.ContainsAny(suffix, "*?[") {
+		return strings.HasSuffix(name, "/"+suffix)
+	}
+	components := strings.Split(name, "/")[1:]
+	count := strings.Count(suffix, "/") + 1
+	if len(components) < count {
+		return false
+	}
+	matched, _ := path.Match(suffix, strings.Join(components[len(components)-count:], "/"))
+	return matched
+}
+
+// matchName reports whether name is one of the paths or extensions the credential file
+// is known by, a credential file recognized by its content alone matches any name
+func (credential *CredentialFile) matchName(name string) bool {
+	if len(credential.Paths) == 0 && len(credential.Extensions) == 0 {
+		return true
+	}
+	for _, suffix := range credential.Paths {
+		if matchPath(name, suffix) {
+			return true
+		}
+	}
+	for _, extension := range credential.Extensions {
+		if strings.EqualFold(path.Ext(name), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCredentialFile returns the kind of credential material held by the file at
+// name, or nil if it holds none
+func MatchCredentialFile(name string, content []byte) *CredentialFile {
+	for _, credential := range CredentialFiles {
+		if !credential.matchName(name) {
+			continue
+		}
+		if credential.Content != nil && !credential.Content.Match(content) {
+			continue
+		}
+		if credential.Verify != nil && !credential.Verify(content) {
+			continue
+		}
+		return credential
+	}
+	return nil
+}
+
+// MayHoldSecrets reports whether the file at name, beginning with head, is worth reading
+// to find secrets: a text file, or a binary known by its name as a credential file, such
+// as a PKCS#12 keystore
+func MayHoldSecrets(name string, head []byte) bool {
+	if !IsBinary(head) {
+		return true
+	}
+	for _, credential := range CredentialFiles {
+		if (len(credential.Paths) > 0 || len(credential.Extensions) > 0) && credential.matchName(name) {
+			return true
+		}
+	}
+	return false
+}
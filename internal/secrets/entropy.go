@@ -0,0 +1,228 @@
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	CHARSET_BASE64 = "base64"
+	CHARSET_HEX    = "hex"
+)
+
+// Kind is a kind of secret found by a detector, reported the way a rule is
+type Kind struct {
+	ID          string
+	Description string
+	Severity    string
+}
+
+var (
+	// EntropyBase64 is a random base64 string following a keyword such as token or password
+	EntropyBase64 = &Kind{
+		ID:          "entropy-001",
+		Description: "High entropy base64 string assigned to a secret-like name, likely a token or a password",
+		Severity:    "High",
+	}
+	// EntropyHex is a random hex string following a keyword such as key or secret
+	EntropyHex = &Kind{
+		ID:          "entropy-002",
+		Description: "High entropy hex string assigned to a secret-like name, likely a key",
+		Severity:    "Medium",
+	}
+	// EntropyString is a long random base64 string without any keyword around it
+	EntropyString = &Kind{
+		ID:          "entropy-003",
+		Description: "Long high entropy base64 string, possibly a token or a key",
+		Severity:    "Low",
+	}
+)
+
+// EntropyKinds are the kinds of secret reported by the entropy detector
+var EntropyKinds = []*Kind{EntropyBase64, EntropyHex, EntropyString}
+
+// DEFAULT_KEYWORDS are the words of a name holding a secret, such as API_TOKEN or db_password
+var DEFAULT_KEYWORDS = []string{
+	"access", "api", "auth", "bearer", "credential", "key", "pass", "private", "pwd", "secret", "signature", "token",
+}
+
+// DEFAULT_IGNORE_KEYWORDS are the words of a name holding a public random value, such as
+// a checksum, a digest or a GPG key fingerprint, they win over DEFAULT_KEYWORDS
+var DEFAULT_IGNORE_KEYWORDS = []string{
+	"checksum", "commit", "digest", "fingerprint", "gpg", "hash", "integrity", "md5", "sha", "uuid", "version",
+}
+
+// contextWords is the number of words before a candidate searched for keywords
+const contextWords = 3
+
+var candidateRegexp = regexp.MustCompile(`[A-Za-z0-9+/_\-]+={0,2}`)
+
+// EntropyConfig configures the entropy detector
+type EntropyConfig struct {
+	// MinLength is the length a candidate must reach, shorter strings are never random enough
+	MinLength int
+	// Base64Threshold and HexThreshold are the entropies, in bits per character, a
+	// candidate following a keyword must reach
+	Base64Threshold float64
+	HexThreshold    float64
+	// StandaloneThreshold is the entropy a base64 candidate without any keyword must reach,
+	// hex candidates are only reported after a keyword as checksums are everywhere
+	StandaloneThreshold float64
+	// Keywords mark the candidates following them on the same line as secrets
+	Keywords []string
+	// IgnoreKeywords mark the candidates following them as public values, such as checksums
+	IgnoreKeywords []string
+}
+
+// DefaultEntropyConfig returns the thresholds and keywords used unless configured otherwise
+func DefaultEntropyConfig() EntropyConfig {
+	return EntropyConfig{
+		MinLength:           20,
+		Base64Threshold:     4.5,
+		HexThreshold:        3.0,
+		StandaloneThreshold: 5.0,
+		Keywords:            DEFAULT_KEYWORDS,
+		IgnoreKeywords:      DEFAULT_IGNORE_KEYWORDS,
+	}
+}
+
+// Secret is a secret found in a text
+type Secret struct {
+	Kind  *Kind
+	Value string
+	// Line is the line of the text holding the secret, from 1
+	Line    int
+	Charset string
+	Entropy float64
+	// Keyword is the keyword found before the secret, empty when there is none
+	Keyword string
+}
+
+// EntropyDetector finds the random strings of a text, scored by their Shannon entropy
+type EntropyDetector struct {
+	config EntropyConfig
+}
+
+// NewEntropyDetector constructs an entropy detector with the specified configuration
+func NewEntropyDetector(config EntropyConfig) *EntropyDetector {
+	return &EntropyDetector{config: config}
+}
+
+// ShannonEntropy returns the Shannon entropy of s, in bits per character
+func ShannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	length := 0
+	for _, r := range s {
+		counts[r]++
+		length++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Find returns the secrets of the text. A candidate is a word of the base64 or hex
+// charset, it is reported when its entropy reaches the threshold of its charset and a
+// keyword precedes it on its line, or when it is a base64 word random enough on its own
+func (d *EntropyDetector) Find(text string) []*Secret {
+	var found []*Secret
+	for i, line := range strings.Split(text, "\n") {
+		for _, loc := range candidateRegexp.FindAllStringIndex(line, -1) {
+			if secret := d.score(line[loc[0]:loc[1]], keywordContext(line[:loc[0]])); secret != nil {
+				secret.Line = i + 1
+				found = append(found, secret)
+			}
+		}
+	}
+	return found
+}
+
+// keywordContext returns the text before a candidate searched for keywords: the name
+// it is assigned to, as in TOKEN=... or "token": "...", or else the words right before
+// it, as in --token ... or Authorization: Bearer ...
+func keywordContext(prefix string) string {
+	prefix = strings.ToLower(prefix)
+	word := prefix[strings.LastIndexAny(prefix, " \t")+1:]
+	if strings.ContainsAny(word, "=:") {
+		return word
+	}
+	fields := strings.Fields(prefix)
+	if len(fields) > contextWords {
+		fields = fields[len(fields)-contextWords:]
+	}
+	return strings.Join(fields, " ")
+}
+
+// score returns the candidate as a secret, or nil when it does not look like one
+func (d *EntropyDetector) score(candidate, context string) *Secret {
+	value := strings.TrimRight(candidate, "=")
+	if len(value) < d.config.MinLength || !plausible(value) {
+		return nil
+	}
+	for _, keyword := range d.config.IgnoreKeywords {
+		if strings.Contains(context, keyword) {
+			return nil
+		}
+	}
+	keyword := ""
+	for _, candidate := range d.config.Keywords {
+		if strings.Contains(context, candidate) {
+			keyword = candidate
+			break
+		}
+	}
+
+	secret := &Secret{Value: value, Charset: charset(value), Entropy: ShannonEntropy(value), Keyword: keyword}
+	switch {
+	case secret.Charset == CHARSET_HEX && keyword != "" && secret.Entropy >= d.config.HexThreshold:
+		secret.Kind = EntropyHex
+	case secret.Charset == CHARSET_BASE64 && keyword != "" && secret.Entropy >= d.config.Base64Threshold:
+		secret.Kind = EntropyBase64
+	case secret.Charset == CHARSET_BASE64 && keyword == "" && secret.Entropy >= d.config.StandaloneThreshold && mixed(value):
+		secret.Kind = EntropyString
+	default:
+		return nil
+	}
+	return secret
+}
+
+// plausible rules out the candidates that are paths or plain numbers
+func plausible(value string) bool {
+	if strings.HasPrefix(value, "/") || strings.HasSuffix(value, "/") || strings.Count(value, "/") > 2 {
+		return false
+	}
+	return strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' }) >= 0
+}
+
+func charset(value string) string {
+	for _, r := range value {
+		if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'f') && !(r >= 'A' && r <= 'F') {
+			return CHARSET_BASE64
+		}
+	}
+	return CHARSET_HEX
+}
+
+// mixed reports whether value holds lower case letters, upper case letters and digits,
+// as random tokens do and identifiers rarely do
+func mixed(value string) bool {
+	var lower, upper, digit bool
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		}
+	}
+	return lower && upper && digit
+}
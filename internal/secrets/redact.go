@@ -0,0 +1,58 @@
+// Package secrets finds secrets in text and files. The commands reporting them never
+// show a secret in full unless asked to: Redact hides most of it, and Fingerprint
+// identifies a finding across runs without depending on the secret
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// redactedMask replaces the hidden part of a secret, whatever its length
+const redactedMask = "*****"
+
+// wordRegexp matches the words of a command or of a line of a shell script
+var wordRegexp = regexp.MustCompile(`\S+`)
+
+// Redact hides a secret for reports, keeping up to 4 characters at each end so that it
+// can still be told apart, a tenth of it at most at each end, so a fifth of it at most
+// is shown. A PEM block keeps its header line, which tells the kind of key and holds
+// nothing secret
+func Redact(value string) string {
+	if strings.HasPrefix(value, "-----BEGIN ") {
+		if header, _, ok := strings.Cut(value[len("-----BEGIN "):], "-----"); ok {
+			return "-----BEGIN " + header + "-----" + redactedMask
+		}
+	}
+	runes := []rune(value)
+	keep := min(4, len(runes)/10)
+	return string(runes[:keep]) + redactedMask + string(runes[len(runes)-keep:])
+}
+
+// RedactText hides the secrets of commands or of lines of a shell script, see Redact:
+// the values of the NAME=value words that sensitive reports, and the random strings
+// the detector finds. The rest of text is kept as written
+func RedactText(text string, detector *EntropyDetector, sensitive func(name, value string) bool) string {
+	text = wordRegexp.ReplaceAllStringFunc(text, func(word string) string {
+		name, value, ok := strings.Cut(word, "=")
+		if !ok || !sensitive(strings.TrimLeft(name, `-"'`), strings.Trim(value, `"';`)) {
+			return word
+		}
+		return name + "=" + Redact(value)
+	})
+	for _, secret := range detector.Find(text) {
+		text = strings.ReplaceAll(text, secret.Value, Redact(secret.Value))
+	}
+	return text
+}
+
+// Fingerprint identifies a finding across runs: the SHA-256, in hex, of the rule ID, the
+// file and the location of the finding in it, such as its line. Nothing matched is
+// hashed, a secret could otherwise be confirmed offline by hashing guesses of it, so
+// the fingerprint changes when the finding moves
+func Fingerprint(id, file, location string) string {
+	sum := sha256.Sum256([]byte(id + "\x00" + file + "\x00" + location))
+	return hex.EncodeToString(sum[:])
+}
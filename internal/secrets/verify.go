@@ -0,0 +1,258 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+const (
+	// CONFIDENCE_HIGH marks a secret whose format passed its offline check
+	CONFIDENCE_HIGH = "high"
+	// CONFIDENCE_LOW marks a secret that only matched a pattern and failed its check,
+	// such as a GitHub token with a wrong checksum, which is usually an example
+	CONFIDENCE_LOW = "low"
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// placeholders are the words of the tokens written in documentation rather than issued
+var placeholders = []string{"example", "sample", "dummy", "placeholder", "xxxxxx", "000000", "123456", "abcdef"}
+
+// Verifier checks the format of a secret offline, without calling the service it belongs to
+type Verifier func(value string) bool
+
+// Verifiers are the offline checks rules refer to by name with verify
+var Verifiers = map[string]Verifier{
+	"azure-storage-key":   VerifyAzureStorageKey,
+	"docker-auth":         VerifyDockerAuth,
+	"gcp-service-account": VerifyGCPServiceAccount,
+	"github-token":        VerifyGitHubToken,
+	"jwt":                 VerifyJWT,
+	"kubeconfig":          VerifyKubeconfigCredential,
+	"npm-token":           VerifyNpmToken,
+	"private-key":         VerifyPrivateKey,
+	"pypi-token":          VerifyPyPIToken,
+	"random":              VerifyRandom,
+	"sendgrid-key":        VerifySendGridKey,
+}
+
+// VerifyRandom checks that a token looks generated: not a placeholder such as
+// xxxxxxxx or EXAMPLE, and random enough for its length
+func VerifyRandom(value string) bool {
+	lower := strings.ToLower(value)
+	for _, placeholder := range placeholders {
+		if strings.Contains(lower, placeholder) {
+			return false
+		}
+	}
+	return ShannonEntropy(value) >= 3.0
+}
+
+// checksum62 returns the CRC32 of body encoded in base62 over 6 characters
+func checksum62(body string) string {
+	n := crc32.ChecksumIEEE([]byte(body))
+	encoded := make([]byte, 6)
+	for i := range encoded {
+		encoded[len(encoded)-1-i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(encoded)
+}
+
+// verifyChecksummedToken checks a prefix_ token made of 30 random characters followed
+// by the base62 CRC32 of these characters, the format of GitHub and npm tokens
+func verifyChecksummedToken(value string) bool {
+	_, token, ok := strings.Cut(value, "_")
+	if !ok || len(token) != 36 {
+		return false
+	}
+	return checksum62(token[:30]) == token[30:]
+}
+
+// VerifyGitHubToken checks the CRC32 checksum of a ghp_, gho_, ghu_, ghs_ or ghr_ token
+func VerifyGitHubToken(value string) bool {
+	return verifyChecksummedToken(value)
+}
+
+// VerifyNpmToken checks the CRC32 checksum of an npm_ access token
+func VerifyNpmToken(value string) bool {
+	return verifyChecksummedToken(value)
+}
+
+// decodeBase64 decodes standard or URL base64, padded or not
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(value, "=")
+	if strings.ContainsAny(value, "-_") {
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
+
+// VerifySendGridKey checks that both parts of an SG.<id>.<secret> key decode to 16 and 32 bytes
+func VerifySendGridKey(value string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != "SG" {
+		return false
+	}
+	id, err := decodeBase64(parts[1])
+	if err != nil || len(id) != 16 {
+		return false
+	}
+	secret, err := decodeBase64(parts[2])
+	return err == nil && len(secret) == 32 && VerifyRandom(parts[2])
+}
+
+// VerifyPyPIToken checks that a pypi- token decodes to a macaroon issued by pypi.org
+// and ending with its 32 bytes signature
+func VerifyPyPIToken(value string) bool {
+	macaroon, err := decodeBase64(strings.TrimPrefix(value, "pypi-"))
+	if err != nil || len(macaroon) < 64 {
+		return false
+	}
+	// Version 2, then the location field holding pypi.org
+	if !bytes.HasPrefix(macaroon, []byte("\x02\x01\x08pypi.org")) {
+		return false
+	}
+	signature := macaroon[len(macaroon)-34:]
+	return signature[0] == 0x06 && signature[1] == 0x20
+}
+
+// VerifyAzureStorageKey checks that an account key decodes to the 64 bytes of a storage key
+func VerifyAzureStorageKey(value string) bool {
+	key, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(key) == 64 && VerifyRandom(value)
+}
+
+// VerifyJWT checks that a token is made of a header naming its algorithm and a payload,
+// both base64 encoded JSON objects
+func VerifyJWT(value string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	var header map[string]interface{}
+	content, err := decodeBase64(parts[0])
+	if err != nil || json.Unmarshal(content, &header) != nil {
+		return false
+	}
+	if alg, ok := header["alg"].(string); !ok || alg == "" {
+		return false
+	}
+	var payload map[string]interface{}
+	content, err = decodeBase64(parts[1])
+	return err == nil && json.Unmarshal(content, &payload) == nil
+}
+
+// normalizePEM undoes the ways a PEM block is written inside a command or a JSON string:
+// escaped line breaks and indented lines
+func normalizePEM(value string) string {
+	value = strings.ReplaceAll(value, `\n`, "\n")
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// VerifyPrivateKey checks that a PEM block holds a private key, parsing its DER content:
+// PKCS#1, PKCS#8, SEC 1, DSA, encrypted PKCS#8 or OpenSSH keys
+func VerifyPrivateKey(value string) bool {
+	block, _ := pem.Decode([]byte(normalizePEM(value)))
+	if block == nil {
+		return false
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		if _, encrypted := block.Headers["DEK-Info"]; encrypted {
+			return true
+		}
+		_, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return err == nil
+	case "EC PRIVATE KEY":
+		if _, encrypted := block.Headers["DEK-Info"]; encrypted {
+			return true
+		}
+		_, err := x509.ParseECPrivateKey(block.Bytes)
+		return err == nil
+	case "PRIVATE KEY":
+		_, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		return err == nil
+	case "ENCRYPTED PRIVATE KEY":
+		var key struct {
+			Algorithm pkix.AlgorithmIdentifier
+			Data      []byte
+		}
+		rest, err := asn1.Unmarshal(block.Bytes, &key)
+		return err == nil && len(rest) == 0 && len(key.Data) > 0
+	case "DSA PRIVATE KEY":
+		var key struct {
+			Version       int
+			P, Q, G, Y, X *big.Int
+		}
+		rest, err := asn1.Unmarshal(block.Bytes, &key)
+		return err == nil && len(rest) == 0
+	case "OPENSSH PRIVATE KEY":
+		return bytes.HasPrefix(block.Bytes, []byte("openssh-key-v1\x00"))
+	}
+	return false
+}
+
+// VerifyGCPServiceAccount checks the private_key of a service account key file: value
+// is either the whole JSON document, or the private_key string as written in it
+func VerifyGCPServiceAccount(value string) bool {
+	var account struct {
+		Type        string `json:"type"`
+		PrivateKey  string `json:"private_key"`
+		ClientEmail string `json:"client_email"`
+	}
+	if json.Unmarshal([]byte(value), &account) == nil {
+		return account.Type == "service_account" && account.ClientEmail != "" && VerifyPrivateKey(account.PrivateKey)
+	}
+	return VerifyPrivateKey(value)
+}
+
+// VerifyKubeconfigCredential checks a credential of a kubeconfig user: client-key-data
+// decoding to a private key, or a token that is a service account JWT
+func VerifyKubeconfigCredential(value string) bool {
+	if VerifyJWT(value) {
+		return true
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && VerifyPrivateKey(string(key))
+}
+
+// VerifyDockerAuth checks that the auth of a docker config.json decodes to user:password
+func VerifyDockerAuth(value string) bool {
+	auth, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(auth), ":")
+	return ok && user != "" && password != ""
+}
+
+// VerifyPKCS12 checks that content is a PKCS#12 keystore, a PFX structure of version 3
+// holding PKCS#7 data or signed data
+func VerifyPKCS12(content []byte) bool {
+	var pfx struct {
+		Version  int
+		AuthSafe struct {
+			ContentType asn1.ObjectIdentifier
+			Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+		}
+		MacData asn1.RawValue `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(content, &pfx); err != nil || pfx.Version != 3 {
+		return false
+	}
+	return pfx.AuthSafe.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}) ||
+		pfx.AuthSafe.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2})
+}
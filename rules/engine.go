@@ -0,0 +1,480 @@
+package rules
+
+import (
+	"fmt"
+	"imgscan/internal/parser"
+	"imgscan/internal/secrets"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SCOPE_ALL       = "all"
+	SCOPE_FINAL     = "final"
+	SCOPE_REACHABLE = "reachable"
+)
+
+// instructionTarget is an entry of Rule.Instructions, such as COPY --chown
+type instructionTarget struct {
+	cmd   string
+	flags []string
+}
+
+// Compiled is a Rule with its targets and patterns compiled, ready to be evaluated
+type Compiled struct {
+	Rule
+	targets []instructionTarget
+	stages  map[string]bool
+	all     []*regexp.Regexp
+	any     []*regexp.Regexp
+	none    []*regexp.Regexp
+	// commands, pipedTo and without are set for the rules matching the commands of RUN instructions
+	commands map[string]bool
+	pipedTo  map[string]bool
+	without  []*regexp.Regexp
+	// verify checks the secrets matched by the regex, nil when the rule sets no verify
+	verify secrets.Verifier
+}
+
+// Compile compiles the targets and the patterns of a rule
+func Compile(r Rule) (*Compiled, error) {
+	compiled := &Compiled{Rule: r}
+
+	for _, target := range r.Instructions {
+		fields := strings.Fields(target)
+		if len(fields) == 0 {
+			continue
+		}
+		instruction := instructionTarget{cmd: strings.ToUpper(fields[0])}
+		for _, flag := range fields[1:] {
+			instruction.flags = append(instruction.flags, strings.TrimPrefix(flag, "--"))
+		}
+		compiled.targets = append(compiled.targets, instruction)
+	}
+
+	if len(r.Commands) > 0 {
+		compiled.commands = make(map[string]bool)
+		for _, command := range r.Commands {
+			compiled.commands[command] = true
+		}
+		if len(compiled.targets) == 0 {
+			compiled.targets = []instructionTarget{{cmd: "RUN"}}
+		}
+	}
+	if len(r.PipedTo) > 0 {
+		compiled.pipedTo = make(map[string]bool)
+		for _, command := range r.PipedTo {
+			compiled.pipedTo[command] = true
+		}
+	}
+
+	switch r.Scope {
+	case "", SCOPE_ALL, SCOPE_FINAL, SCOPE_REACHABLE:
+	default:
+		return nil, fmt.Errorf("unknown scope %s for rule %s", r.Scope, r.ID)
+	}
+
+	if len(r.Stages) > 0 {
+		compiled.stages = make(map[string]bool)
+		for _, stage := range r.Stages {
+			compiled.stages[strings.ToLower(stage)] = true
+		}
+	}
+
+	var err error
+	allOf := r.AllOf
+	if r.Regex != "" {
+		allOf = append([]string{r.Regex}, allOf...)
+	}
+	if compiled.all, err = compilePatterns(r, allOf); err != nil {
+		return nil, err
+	}
+	if compiled.any, err = compilePatterns(r, r.AnyOf); err != nil {
+		return nil, err
+	}
+	if compiled.none, err = compilePatterns(r, r.NoneOf); err != nil {
+		return nil, err
+	}
+	if compiled.without, err = compilePatterns(r, r.WithoutCommands); err != nil {
+		return nil, err
+	}
+
+	if r.Verify != "" {
+		verify, ok := secrets.Verifiers[r.Verify]
+		if !ok {
+			return nil, fmt.Errorf("unknown verify %s for rule %s", r.Verify, r.ID)
+		}
+		if r.Regex == "" {
+			return nil, fmt.Errorf("verify needs a regex matching the secret for rule %s", r.ID)
+		}
+		compiled.verify = verify
+	}
+	return compiled, nil
+}
+
+func compilePatterns(r Rule, patterns []string) ([]*regexp.Regexp, error) {
+	var regexes []*regexp.Regexp
+	for _, pattern := range patterns {
+		if r.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regex for rule %s: %w", r.ID, err)
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes, nil
+}
+
+// buildScope describes the build the rules are evaluated for
+type buildScope struct {
+	// final is the stage the build produces, the last one unless --target selects another
+	final     *parser.Stage
+	reachable map[*parser.Stage]bool
+	// expanded holds the text of the instructions with the ARG and ENV variables substituted
+	expanded map[*parser.Instruction]string
+	// commands holds the shell commands of the RUN instructions
+	commands map[*parser.Instruction][]*parser.Command
+}
+
+// texts returns the text rules are evaluated against: the instruction as written,
+// and with its variables expanded when that differs
+func (s buildScope) texts(instruction *parser.Instruction) []string {
+	if expanded, ok := s.expanded[instruction]; ok && expanded != instruction.Original {
+		return []string{instruction.Original, expanded}
+	}
+	return []string{instruction.Original}
+}
+
+// inScope reports whether the rule applies to the stage, stage is nil for the
+// instructions before the first FROM
+func (r *Compiled) inScope(stage *parser.Stage, scope buildScope) bool {
+	switch r.Scope {
+	case SCOPE_FINAL:
+		if stage == nil || stage != scope.final {
+			return false
+		}
+	case SCOPE_REACHABLE:
+		if stage == nil || !scope.reachable[stage] {
+			return false
+		}
+	}
+	if r.stages != nil {
+		if stage == nil || !(r.stages[stage.String()] || r.stages[strconv.Itoa(stage.Index)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// appliesTo reports whether the rule targets the instruction of the stage. A FROM
+// instruction building on an earlier stage pulls no image and is never targeted
+func (r *Compiled) appliesTo(instruction *parser.Instruction, stage *parser.Stage) bool {
+	if instruction.Cmd == "FROM" && stage != nil && stage.BaseStage != nil {
+		return false
+	}
+	if !hasFlags(instruction, r.Flags) {
+		return false
+	}
+	if len(r.targets) == 0 {
+		return true
+	}
+	for _, target := range r.targets {
+		if target.cmd == instruction.Cmd && hasFlags(instruction, target.flags) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFlags(instruction *parser.Instruction, flags []string) bool {
+	for _, flag := range flags {
+		if _, ok := instruction.Flag(flag); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the patterns of the rule agree on one of the texts of an
+// instruction. A text matching none_of rules the instruction out, whichever text the
+// other patterns matched, so a FROM ${BASE} pinned through its ARG is not unpinned
+func (r *Compiled) match(texts ...string) bool {
+	matched := false
+	for _, text := range texts {
+		for _, regex := range r.none {
+			if regex.MatchString(text) {
+				return false
+			}
+		}
+		if !matched {
+			matched = r.matchPositive(text)
+		}
+	}
+	return matched
+}
+
+func (r *Compiled) matchPositive(text string) bool {
+	for _, regex := range r.all {
+		if !regex.MatchString(text) {
+			return false
+		}
+	}
+	if len(r.any) == 0 {
+		return true
+	}
+	for _, regex := range r.any {
+		if regex.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCommands reports whether a command of a RUN instruction, or a command run by a
+// wrapper such as sudo, is one the rule applies to and matches its patterns. A command
+// matching without_commands rules the instruction out
+func (r *Compiled) matchCommands(commands []*parser.Command) bool {
+	for _, command := range commands {
+		for run := command; run != nil; run = run.Wrapped {
+			for _, regex := range r.without {
+				if regex.MatchString(run.String()) {
+					return false
+				}
+			}
+		}
+	}
+
+	for _, command := range commands {
+		if !r.pipes(command) {
+			continue
+		}
+		for run := command; run != nil; run = run.Wrapped {
+			if r.commands[run.Name()] && r.match(run.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pipes reports whether the output of the command is piped into one of the programs
+// of piped_to, further down the pipeline, always true when piped_to is not set
+func (r *Compiled) pipes(command *parser.Command) bool {
+	if r.pipedTo == nil {
+		return true
+	}
+	for next := command.PipedTo; next != nil; next = next.PipedTo {
+		for run := next; run != nil; run = run.Wrapped {
+			if r.pipedTo[run.Name()] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// secret returns the secret matched by the regex of a rule setting verify in the texts
+// of an instruction, with the confidence of its offline check. The first secret passing
+// the check wins, or else the first secret found
+func (r *Compiled) secret(texts ...string) (string, string) {
+	regex := r.all[0]
+	group := regex.SubexpIndex("secret")
+	found := ""
+	for _, text := range texts {
+		for _, m := range regex.FindAllStringSubmatch(text, -1) {
+			value := m[0]
+			if group > 0 && m[group] != "" {
+				value = m[group]
+			}
+			if r.verify(value) {
+				return value, secrets.CONFIDENCE_HIGH
+			}
+			if found == "" {
+				found = value
+			}
+		}
+	}
+	return found, secrets.CONFIDENCE_LOW
+}
+
+// missing checks a required rule against a stage, and returns the instruction the
+// finding is reported at when nothing matches, or nil when the requirement is met.
+// A stage built on an earlier stage inherits its instructions
+func (r *Compiled) missing(stage *parser.Stage, scope buildScope) *parser.Instruction {
+	var instructions []*parser.Instruction
+	stageOf := make(map[*parser.Instruction]*parser.Stage)
+	for current := stage; current != nil; current = current.BaseStage {
+		instructions = append(append([]*parser.Instruction{}, current.Instructions...), instructions...)
+		for _, instruction := range current.Instructions {
+			stageOf[instruction] = current
+		}
+	}
+
+	at := stage.Instructions[0]
+	if r.Before != "" {
+		before := strings.ToUpper(r.Before)
+		at = nil
+		for i, instruction := range instructions {
+			if instruction.Cmd == before {
+				instructions, at = instructions[:i], instruction
+				break
+			}
+		}
+		// The base stage the instruction belongs to reports it
+		if at == nil || stageOf[at] != stage {
+			return nil
+		}
+	}
+
+	var candidates []*parser.Instruction
+	for _, instruction := range instructions {
+		if r.appliesTo(instruction, stageOf[instruction]) {
+			candidates = append(candidates, instruction)
+		}
+	}
+	if r.Last && len(candidates) > 0 {
+		candidates = candidates[len(candidates)-1:]
+	}
+
+	for _, instruction := range candidates {
+		if r.match(scope.texts(instruction)...) {
+			return nil
+		}
+	}
+	return at
+}
+
+// Match is an instruction matched by a rule, or the instruction a required rule
+// missing from a stage is reported at
+type Match struct {
+	Rule        Rule
+	Instruction *parser.Instruction
+	// Stage is nil for the instructions before the first FROM
+	Stage *parser.Stage
+	// Secret and Confidence are set for the rules setting verify
+	Secret     string
+	Confidence string
+}
+
+// Evaluate evaluates every rule against each instruction it applies to, as written
+// and with its variables expanded with buildArgs, or against its shell commands for the
+// rules setting commands. A rule matching several instructions
+// matches once per instruction, and a required rule once per stage that misses it.
+// target selects the stage the build produces, the last one when empty
+func Evaluate(dockerfile *parser.Dockerfile, ruleSet []*Compiled, target string, buildArgs map[string]string) ([]Match, error) {
+	var matches []Match
+
+	stages := dockerfile.Stages()
+	scope := buildScope{
+		expanded: dockerfile.Expand(buildArgs),
+		commands: make(map[*parser.Instruction][]*parser.Command),
+	}
+	if len(stages) > 0 || target != "" {
+		final, err := parser.Target(stages, target)
+		if err != nil {
+			return nil, err
+		}
+		scope.final, scope.reachable = final, parser.Reachable(final)
+	}
+	stageOf := make(map[*parser.Instruction]*parser.Stage)
+	for _, stage := range stages {
+		for _, instruction := range stage.Instructions {
+			stageOf[instruction] = stage
+		}
+	}
+	for _, instruction := range dockerfile.Instructions {
+		if instruction.Cmd == "RUN" {
+			scope.commands[instruction] = instruction.Commands()
+		}
+	}
+
+	for _, rule := range ruleSet {
+		if rule.Required {
+			for _, stage := range stages {
+				if !rule.inScope(stage, scope) {
+					continue
+				}
+				if at := rule.missing(stage, scope); at != nil {
+					matches = append(matches, Match{Rule: rule.Rule, Instruction: at, Stage: stage})
+				}
+			}
+			continue
+		}
+
+		for _, instruction := range dockerfile.Instructions {
+			stage := stageOf[instruction]
+			if !rule.inScope(stage, scope) || !rule.appliesTo(instruction, stage) {
+				continue
+			}
+			matched := false
+			if rule.commands != nil {
+				matched = rule.matchCommands(scope.commands[instruction])
+			} else {
+				matched = rule.match(scope.texts(instruction)...)
+			}
+			if !matched {
+				continue
+			}
+			match := Match{Rule: rule.Rule, Instruction: instruction, Stage: stage}
+			if rule.verify != nil {
+				match.Secret, match.Confidence = rule.secret(scope.texts(instruction)...)
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+// AppliesToFiles reports whether the rule can be evaluated against the content of a
+// file, the way image secrets scans the files of an image: it needs a regex locating
+// what it matched, and no target that only exists in a Dockerfile
+func (r *Compiled) AppliesToFiles() bool {
+	return r.Regex != "" && !r.Required && len(r.targets) == 0 && len(r.Flags) == 0 &&
+		len(r.Stages) == 0 && r.commands == nil
+}
+
+// FileMatch is a match of a rule in the content of a file
+type FileMatch struct {
+	Rule Rule
+	// Line is the line the match starts on, from 1
+	Line int
+	// Secret is the group named secret of the regex, or its whole match
+	Secret string
+	// Confidence is set for the rules setting verify
+	Confidence string
+}
+
+// MatchFile evaluates a rule that applies to files against the content of a file. The
+// patterns must agree on the content as a whole, and every match of the regex is then
+// reported on its own line
+func (r *Compiled) MatchFile(content string) []FileMatch {
+	if !r.match(content) {
+		return nil
+	}
+
+	regex := r.all[0]
+	group := regex.SubexpIndex("secret")
+	var matches []FileMatch
+	for _, loc := range regex.FindAllStringSubmatchIndex(content, -1) {
+		start, end := loc[0], loc[1]
+		if group > 0 && loc[2*group] >= 0 {
+			start, end = loc[2*group], loc[2*group+1]
+		}
+		match := FileMatch{
+			Rule:   r.Rule,
+			Line:   strings.Count(content[:start], "\n") + 1,
+			Secret: content[start:end],
+		}
+		if r.verify != nil {
+			match.Confidence = secrets.CONFIDENCE_LOW
+			if r.verify(match.Secret) {
+				match.Confidence = secrets.CONFIDENCE_HIGH
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
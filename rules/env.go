@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DEFAULT_ENV_KEYS are the patterns of the names of environment variables holding secrets
+var DEFAULT_ENV_KEYS = []string{
+	"*PASSWORD*", "*PASSWD*", "*_PWD", "*SECRET*", "TOKEN", "*_TOKEN", "*_KEY", "*_APIKEY", "*_CREDENTIALS", "*_AUTH", "AWS_*",
+}
+
+// DEFAULT_ENV_IGNORE_KEYS are the patterns of names matching DEFAULT_ENV_KEYS that hold
+// public values: GPG key fingerprints, public keys, paths to secret files and AWS settings
+var DEFAULT_ENV_IGNORE_KEYS = []string{
+	"GPG_KEY", "*_GPG_KEY", "*_PUBLIC_KEY", "*_FILE", "*_PATH", "*_DIR", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE",
+}
+
+// DEFAULT_ENV_PLACEHOLDERS are the values written in place of a secret, or that cannot be one
+var DEFAULT_ENV_PLACEHOLDERS = []string{
+	"changeme", "change_me", "changeit", "password", "secret", "example", "dummy", "placeholder", "todo",
+	"none", "null", "nil", "undefined", "true", "false", "yes", "no", "on", "off",
+}
+
+// variableRegexp matches a value that only refers to another variable, such as ${DB_PASSWORD}
+var variableRegexp = regexp.MustCompile(`^\$(\{[A-Za-z_][A-Za-z0-9_]*([:?+-][^}]*)?\}|[A-Za-z_][A-Za-z0-9_]*)$`)
+
+// EnvRules tells the environment variables holding secrets apart, by their name, and
+// the values that are placeholders. Names and values are compared case-insensitively
+type EnvRules struct {
+	// Keys are the patterns, in the syntax of path.Match, of the names holding secrets
+	Keys []string `yaml:"keys,omitempty" json:"keys,omitempty"`
+	// IgnoreKeys are the patterns of the names holding public values, they win over Keys
+	IgnoreKeys []string `yaml:"ignore_keys,omitempty" json:"ignore_keys,omitempty"`
+	// Placeholders are the values that are not secrets, such as changeme. Empty values and
+	// values only referring to another variable, such as ${DB_PASSWORD}, are never secrets
+	Placeholders []string `yaml:"placeholders,omitempty" json:"placeholders,omitempty"`
+}
+
+// DefaultEnvRules returns the env rules used unless an env rules file replaces them
+func DefaultEnvRules() EnvRules {
+	return EnvRules{
+		Keys:         DEFAULT_ENV_KEYS,
+		IgnoreKeys:   DEFAULT_ENV_IGNORE_KEYS,
+		Placeholders: DEFAULT_ENV_PLACEHOLDERS,
+	}
+}
+
+// LoadEnvRules returns the default env rules, with the lists set by the YAML or JSON
+// file or URL replacing the default ones
+func LoadEnvRules(source string) (EnvRules, error) {
+	envRules := DefaultEnvRules()
+	var content []byte
+	var err error
+	if strings.HasPrefix(source, "http") {
+		content, err = download(source)
+	} else {
+		content, err = readFile(source)
+	}
+	if err != nil {
+		return envRules, err
+	}
+
+	var custom EnvRules
+	if err := yaml.UnmarshalStrict(content, &custom); err != nil {
+		return envRules, fmt.Errorf("failed to unmarshal env rules from %s: %w", source, err)
+	}
+	for _, pattern := range append(append([]string{}, custom.Keys...), custom.IgnoreKeys...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return envRules, fmt.Errorf("invalid env key pattern %q in %s: %w", pattern, source, err)
+		}
+	}
+	if custom.Keys != nil {
+		envRules.Keys = custom.Keys
+	}
+	if custom.IgnoreKeys != nil {
+		envRules.IgnoreKeys = custom.IgnoreKeys
+	}
+	if custom.Placeholders != nil {
+		envRules.Placeholders = custom.Placeholders
+	}
+	return envRules, nil
+}
+
+// matchKey returns the first of the patterns matching name, or an empty string
+func matchKey(patterns []string, name string) string {
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToUpper(pattern), name); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// SensitiveKey returns the pattern of Keys matching name, or an empty string when none
+// does or when a pattern of IgnoreKeys does
+func (e EnvRules) SensitiveKey(name string) string {
+	if matchKey(e.IgnoreKeys, name) != "" {
+		return ""
+	}
+	return matchKey(e.Keys, name)
+}
+
+// Placeholder reports whether value stands for a secret without being one: empty, one
+// of Placeholders, a reference to another variable, a <value> to fill in, or the same
+// character repeated such as xxxxxxxx
+func (e EnvRules) Placeholder(value string) bool {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	if value == "" || variableRegexp.MatchString(value) || strings.Count(value, value[:1]) == len(value) {
+		return true
+	}
+	if strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">") {
+		return true
+	}
+	for _, placeholder := range e.Placeholders {
+		if strings.EqualFold(value, placeholder) {
+			return true
+		}
+	}
+	return false
+}
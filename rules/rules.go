@@ -0,0 +1,253 @@
+package rules
+
+import (
+	"embed"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RULES_PATH_ENV lists directories, separated like PATH, holding packs that override the embedded ones
+const RULES_PATH_ENV = "IMGSCAN_RULES_PATH"
+
+// EMBEDDED_PREFIX marks the source of the packs built into imgscan
+const EMBEDDED_PREFIX = "embedded:"
+
+// embedded holds the default packs, so imgscan finds them wherever it runs from
+//
+//go:embed *.yaml
+var embedded embed.FS
+
+// Rule represents a rule for Dockerfile analysis. A rule is evaluated against each
+// instruction on its own, Instructions, Flags, Scope and Stages restrict the instructions
+// it applies to, and the patterns must all agree for the rule to match
+type Rule struct {
+	ID          string `yaml:"id" json:"id"`
+	Description string `yaml:"description" json:"description"`
+	Regex       string `yaml:"regex" json:"regex"`
+	Reference   string `yaml:"reference" json:"reference"`
+	Severity    string `yaml:"severity" json:"severity"`
+	// Instructions are the instructions the rule applies to, such as RUN or COPY --chown,
+	// a flag written after the instruction must be set on it. Any instruction when empty
+	Instructions []string `yaml:"instructions,omitempty" json:"instructions,omitempty"`
+	// Flags must all be set on the instruction, such as chown or mount
+	Flags []string `yaml:"flags,omitempty" json:"flags,omitempty"`
+	// Stages are the names or indexes of the build stages the rule applies to, any stage when empty
+	Stages []string `yaml:"stages,omitempty" json:"stages,omitempty"`
+	// IgnoreCase makes every pattern of the rule case-insensitive
+	IgnoreCase bool `yaml:"ignore_case,omitempty" json:"ignore_case,omitempty"`
+	// AllOf patterns must all match
+	AllOf []string `yaml:"all_of,omitempty" json:"all_of,omitempty"`
+	// AnyOf patterns must match at least once when set
+	AnyOf []string `yaml:"any_of,omitempty" json:"any_of,omitempty"`
+	// NoneOf patterns must not match
+	NoneOf []string `yaml:"none_of,omitempty" json:"none_of,omitempty"`
+	// Scope selects the stages the rule applies to: all (default), final for the
+	// stage the build produces, or reachable for the stages that build runs
+	Scope string `yaml:"scope,omitempty" json:"scope,omitempty"`
+	// Required reports each stage in which no instruction the rule applies to
+	// matches, instead of each instruction that matches
+	Required bool `yaml:"required,omitempty" json:"required,omitempty"`
+	// Last restricts a required rule to the last instruction it applies to in the stage
+	Last bool `yaml:"last,omitempty" json:"last,omitempty"`
+	// Before restricts a required rule to the instructions preceding the first
+	// instruction of this kind in the stage, stages without one are not checked
+	Before string `yaml:"before,omitempty" json:"before,omitempty"`
+	// Commands are the programs, such as apt-get or curl, whose commands in the shell of
+	// RUN instructions the rule applies to. The patterns then match each command on its own,
+	// written as the program name followed by its arguments without quotes
+	Commands []string `yaml:"commands,omitempty" json:"commands,omitempty"`
+	// PipedTo restricts a command rule to the commands piping their output into one of these programs
+	PipedTo []string `yaml:"piped_to,omitempty" json:"piped_to,omitempty"`
+	// WithoutCommands patterns rule out the RUN instructions running a command they match
+	WithoutCommands []string `yaml:"without_commands,omitempty" json:"without_commands,omitempty"`
+	// Verify names the offline check of the secret matched by Regex, such as github-token,
+	// see secrets.Verifiers. The secret is the group named secret of Regex, or its whole match
+	Verify string `yaml:"verify,omitempty" json:"verify,omitempty"`
+	// Examples are checked by imgscan rules test
+	Examples *Examples `yaml:"examples,omitempty" json:"-"`
+	// Source is the file or URL the rule was loaded from, embedded:<pack> for a default pack
+	Source string `yaml:"-" json:"source,omitempty"`
+}
+
+// Pack is a YAML file of rules
+type Pack struct {
+	// Name is the file name of the pack, such as core.yaml
+	Name string
+	// Source is the path the pack is read from, or embedded:<name>
+	Source string
+}
+
+// SearchPath returns the directories searched for packs before the embedded ones:
+// rulesDir first, then the entries of IMGSCAN_RULES_PATH
+func SearchPath(rulesDir string) []string {
+	var dirs []string
+	if rulesDir != "" {
+		dirs = append(dirs, rulesDir)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv(RULES_PATH_ENV)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func isPack(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
+
+// DefaultPacks returns the default packs, each one read from the first directory of
+// the search path holding a file of the same name, or else from its embedded copy.
+// The other packs found in the search path are default packs too
+func DefaultPacks(rulesDir string) ([]Pack, error) {
+	packs := make(map[string]Pack)
+	entries, err := fs.ReadDir(embedded, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded rules: %w", err)
+	}
+	for _, entry := range entries {
+		packs[entry.Name()] = Pack{Name: entry.Name(), Source: EMBEDDED_PREFIX + entry.Name()}
+	}
+
+	overridden := make(map[string]bool)
+	for _, dir := range SearchPath(rulesDir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			// A missing directory of IMGSCAN_RULES_PATH is skipped like a missing PATH entry
+			if dir == rulesDir {
+				return nil, fmt.Errorf("failed to read rules directory: %w", err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !isPack(entry.Name()) || overridden[entry.Name()] {
+				continue
+			}
+			overridden[entry.Name()] = true
+			packs[entry.Name()] = Pack{Name: entry.Name(), Source: filepath.Join(dir, entry.Name())}
+		}
+	}
+
+	var result []Pack
+	for _, pack := range packs {
+		result = append(result, pack)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// Load returns the rules of the pack
+func (p Pack) Load() ([]Rule, error) {
+	if name, ok := strings.CutPrefix(p.Source, EMBEDDED_PREFIX); ok {
+		content, err := embedded.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded rules: %w", err)
+		}
+		return parseRules(content, p.Source)
+	}
+	return LoadFile(p.Source)
+}
+
+// LoadFile returns the rules of a YAML or JSON file
+func LoadFile(filePath string) ([]Rule, error) {
+	content, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(content, filePath)
+}
+
+// LoadURL returns the rules of a YAML or JSON file downloaded from url
+func LoadURL(url string) ([]Rule, error) {
+	content, err := download(url)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(content, url)
+}
+
+func readFile(filePath string) ([]byte, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	return content, nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download rules from URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules from response: %w", err)
+	}
+	return content, nil
+}
+
+func parseRules(content []byte, source string) ([]Rule, error) {
+	var rules []Rule
+	if err := yaml.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules from %s: %w", source, err)
+	}
+	for i := range rules {
+		rules[i].Source = source
+	}
+	return rules, nil
+}
+
+// Load returns the effective rule set: the rules of the default packs selected by
+// mode, all of them, none of them, or the one named mode, such as core for core.yaml,
+// followed by the rules of the custom files or URLs
+func Load(mode, rulesDir string, custom []string) ([]Rule, error) {
+	var rules []Rule
+	if mode != "none" {
+		packs, err := DefaultPacks(rulesDir)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, pack := range packs {
+			name := strings.TrimSuffix(strings.TrimSuffix(pack.Name, ".yaml"), ".yml")
+			if mode != "all" && mode != name {
+				continue
+			}
+			found = true
+			r, err := pack.Load()
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, r...)
+		}
+		if !found {
+			return nil, fmt.Errorf("no rules pack named %s", mode)
+		}
+	}
+
+	for _, file := range custom {
+		load := LoadFile
+		if strings.HasPrefix(file, "http") {
+			load = LoadURL
+		}
+		r, err := load(file)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r...)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,45 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	override := "- id: core-001\n  description: overridden\n  severity: Low\n  regex: x\n"
+	if err := os.WriteFile(filepath.Join(dir, "core.yaml"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	custom := filepath.Join(dir, "custom.yml")
+	if err := os.WriteFile(custom, []byte("- id: custom-001\n  description: c\n  severity: Low\n  regex: y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(RULES_PATH_ENV, "")
+
+	ruleSet, err := Load("core", dir, []string{custom})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var ids, sources []string
+	for _, rule := range ruleSet {
+		ids = append(ids, rule.ID)
+		sources = append(sources, rule.Source)
+	}
+	if !reflect.DeepEqual(ids, []string{"core-001", "custom-001"}) || sources[0] != filepath.Join(dir, "core.yaml") {
+		t.Errorf("Load() = %v from %v, want the overriding pack then the custom file", ids, sources)
+	}
+
+	ruleSet, err = Load("none", "", []string{custom})
+	if err != nil || len(ruleSet) != 1 {
+		t.Errorf("Load(none) = %d rules, %v, want the custom rule only", len(ruleSet), err)
+	}
+	if _, err := Load("missing", "", nil); err == nil {
+		t.Errorf("Load(missing) succeeded, want an error")
+	}
+	if _, err := Load("all", filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("Load() with a missing rules directory succeeded, want an error")
+	}
+}
@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"imgscan/internal/parser"
+	"net/url"
+	"strings"
+)
+
+// SEVERITIES are the severities a rule may have
+var SEVERITIES = []string{"Low", "Medium", "High", "Critical"}
+
+// Examples are Dockerfile snippets a rule must match, or must not, run by imgscan rules test
+type Examples struct {
+	Match   []string `yaml:"match,omitempty"`
+	NoMatch []string `yaml:"no_match,omitempty"`
+}
+
+// ValidationError is a problem found in a rule
+type ValidationError struct {
+	ID      string
+	Source  string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: rule %s: %s", e.Source, e.ID, e.Message)
+}
+
+// Validate checks the rule set for missing fields, duplicate IDs, unknown severities,
+// patterns that do not compile, malformed references and fields that do not go together,
+// and returns every problem found
+func Validate(ruleSet []Rule) []ValidationError {
+	var problems []ValidationError
+	seen := make(map[string]Rule)
+	for _, rule := range ruleSet {
+		problems = append(problems, validateRule(rule, seen)...)
+	}
+	return problems
+}
+
+// Usable returns the rules of the set that are not ignored, or an error listing the
+// problems of those that do not pass validation. Scans stop on it rather than run
+// without a rule the user counts on, an ignored rule is never checked
+func Usable(ruleSet []Rule, ignoreIDs map[string]bool) ([]Rule, error) {
+	var usable []Rule
+	var problems []error
+	seen := make(map[string]Rule)
+	for _, rule := range ruleSet {
+		if ignoreIDs[rule.ID] {
+			continue
+		}
+		for _, problem := range validateRule(rule, seen) {
+			problems = append(problems, problem)
+		}
+		usable = append(usable, rule)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%d problems found in the rules, fix them or ignore the rules: %w", len(problems), errors.Join(problems...))
+	}
+	return usable, nil
+}
+
+// validateRule checks a rule, seen holds the rules checked before it by their ID
+func validateRule(rule Rule, seen map[string]Rule) []ValidationError {
+	var problems []ValidationError
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, ValidationError{
+			ID:      rule.ID,
+			Source:  rule.Source,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if rule.ID == "" {
+		report("missing id")
+	} else if previous, ok := seen[rule.ID]; ok {
+		report("duplicate id, also defined in %s", previous.Source)
+	} else {
+		seen[rule.ID] = rule
+	}
+	if rule.Description == "" {
+		report("missing description")
+	}
+
+	if rule.Severity == "" {
+		report("missing severity")
+	} else if !validSeverity(rule.Severity) {
+		report("unknown severity %s, must be one of %s", rule.Severity, strings.Join(SEVERITIES, ", "))
+	}
+
+	if rule.Reference != "" {
+		reference, err := url.Parse(rule.Reference)
+		if err != nil || (reference.Scheme != "http" && reference.Scheme != "https") || reference.Host == "" {
+			report("reference %s is not an http or https URL", rule.Reference)
+		}
+	}
+
+	if rule.Regex == "" && len(rule.AllOf) == 0 && len(rule.AnyOf) == 0 && len(rule.NoneOf) == 0 &&
+		len(rule.Instructions) == 0 && len(rule.Flags) == 0 && len(rule.Commands) == 0 && !rule.Required {
+		report("matches every instruction, set regex, all_of, any_of, none_of, instructions or commands")
+	}
+	if (rule.Last || rule.Before != "") && !rule.Required {
+		report("last and before only apply to required rules")
+	}
+	if len(rule.Commands) > 0 {
+		if rule.Required {
+			report("commands do not apply to required rules")
+		}
+		for _, instruction := range rule.Instructions {
+			if fields := strings.Fields(instruction); len(fields) > 0 && strings.ToUpper(fields[0]) != "RUN" {
+				report("commands only apply to RUN instructions, not %s", fields[0])
+			}
+		}
+	} else if len(rule.PipedTo) > 0 || len(rule.WithoutCommands) > 0 {
+		report("piped_to and without_commands only apply to rules setting commands")
+	}
+	if rule.Verify != "" && rule.Required {
+		report("verify does not apply to required rules")
+	}
+	if _, err := Compile(rule); err != nil {
+		report("%v", err)
+	}
+	return problems
+}
+
+func validSeverity(severity string) bool {
+	for _, allowed := range SEVERITIES {
+		if allowed == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// ExampleResult is the outcome of an example of a rule
+type ExampleResult struct {
+	Rule    Rule
+	Example string
+	// WantMatch tells a match example from a no_match one
+	WantMatch bool
+	Matched   bool
+	// Err is set when the example is not a valid Dockerfile
+	Err error
+}
+
+// Passed reports whether the rule behaved as the example expects
+func (r ExampleResult) Passed() bool {
+	return r.Err == nil && r.Matched == r.WantMatch
+}
+
+// RunExamples evaluates the rule against each of its examples. An example without a
+// FROM instruction is evaluated as the only stage of the build, after FROM scratch
+func RunExamples(rule Rule) ([]ExampleResult, error) {
+	if rule.Examples == nil {
+		return nil, nil
+	}
+	compiled, err := Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ExampleResult
+	run := func(example string, wantMatch bool) {
+		result := ExampleResult{Rule: rule, Example: example, WantMatch: wantMatch}
+		result.Matched, result.Err = matchExample(compiled, example)
+		results = append(results, result)
+	}
+	for _, example := range rule.Examples.Match {
+		run(example, true)
+	}
+	for _, example := range rule.Examples.NoMatch {
+		run(example, false)
+	}
+	return results, nil
+}
+
+func matchExample(rule *Compiled, example string) (bool, error) {
+	dockerfile, err := parser.Parse(strings.NewReader(example))
+	if err != nil {
+		return false, err
+	}
+	if len(dockerfile.Stages()) == 0 {
+		dockerfile, err = parser.Parse(strings.NewReader("FROM scratch\n" + example))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	matches, err := Evaluate(dockerfile, []*Compiled{rule}, "", nil)
+	if err != nil {
+		return false, err
+	}
+	return len(matches) > 0, nil
+}
@@ -0,0 +1,109 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Rule{ID: "test-001", Description: "USER root", Severity: "High", Regex: `^USER root`, Source: "custom.yaml"}
+	tests := []struct {
+		name string
+		edit func(rule *Rule)
+		// messages are the start of the messages of the problems found
+		messages []string
+	}{
+		{"valid", func(rule *Rule) {}, nil},
+		{"missing fields", func(rule *Rule) { *rule = Rule{Regex: "a"} }, []string{"missing id", "missing description", "missing severity"}},
+		{"unknown severity", func(rule *Rule) { rule.Severity = "high" }, []string{"unknown severity high"}},
+		{"reference", func(rule *Rule) { rule.Reference = "docs/rules.md" }, []string{"reference docs/rules.md is not an http or https URL"}},
+		{"matches everything", func(rule *Rule) { rule.Regex = "" }, []string{"matches every instruction"}},
+		{"last without required", func(rule *Rule) { rule.Last = true }, []string{"last and before only apply to required rules"}},
+		{"commands of COPY", func(rule *Rule) {
+			rule.Commands = []string{"curl"}
+			rule.Instructions = []string{"COPY"}
+		}, []string{"commands only apply to RUN instructions, not COPY"}},
+		{"piped_to without commands", func(rule *Rule) { rule.PipedTo = []string{"sh"} }, []string{"piped_to and without_commands only apply"}},
+		{"required verify", func(rule *Rule) {
+			rule.Required = true
+			rule.Verify = "jwt"
+		}, []string{"verify does not apply to required rules"}},
+		{"unknown verify", func(rule *Rule) { rule.Verify = "aws-key" }, []string{"unknown verify aws-key"}},
+		{"invalid regex", func(rule *Rule) { rule.AnyOf = []string{"("} }, []string{"failed to compile regex for rule test-001"}},
+		{"unknown scope", func(rule *Rule) { rule.Scope = "last" }, []string{"unknown scope last"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.edit(&rule)
+			problems := Validate([]Rule{rule})
+			if len(problems) != len(tt.messages) {
+				t.Fatalf("Validate() = %v, want %d problems", problems, len(tt.messages))
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem.Message, tt.messages[i]) {
+					t.Errorf("problem %q, want %q", problem.Message, tt.messages[i])
+				}
+			}
+		})
+	}
+
+	problems := Validate([]Rule{valid, valid})
+	if len(problems) != 1 || problems[0].Error() != "custom.yaml: rule test-001: duplicate id, also defined in custom.yaml" {
+		t.Errorf("Validate() of a duplicate = %v", problems)
+	}
+}
+
+func TestUsable(t *testing.T) {
+	good := Rule{ID: "test-001", Description: "d", Severity: "Low", Regex: "a"}
+	broken := Rule{ID: "test-002", Description: "d", Severity: "Low", Regex: "("}
+	duplicate := Rule{ID: "test-001", Description: "d", Severity: "Low", Regex: "b"}
+
+	usable, err := Usable([]Rule{good, broken, duplicate}, nil)
+	if usable != nil || err == nil {
+		t.Fatalf("Usable() = %+v, %v, want an error", usable, err)
+	}
+	var problem ValidationError
+	if !errors.As(err, &problem) || problem.ID != "test-002" || !strings.HasPrefix(err.Error(), "2 problems found") {
+		t.Errorf("Usable() error = %v, want the broken rule and the duplicate", err)
+	}
+
+	// An ignored rule is neither used nor checked
+	usable, err = Usable([]Rule{good, broken}, map[string]bool{"test-002": true})
+	if !reflect.DeepEqual(usable, []Rule{good}) || err != nil {
+		t.Errorf("Usable() ignoring test-002 = %+v, %v", usable, err)
+	}
+}
+
+// TestPacks checks the rules of the default packs and runs their examples, as
+// imgscan rules test does
+func TestPacks(t *testing.T) {
+	ruleSet, err := Load("all", "", nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(ruleSet) == 0 {
+		t.Fatalf("Load() found no rules")
+	}
+	for _, problem := range Validate(ruleSet) {
+		t.Errorf("%v", problem)
+	}
+
+	for _, rule := range ruleSet {
+		if rule.Examples == nil || len(rule.Examples.Match) == 0 {
+			t.Errorf("rule %s has no match example", rule.ID)
+		}
+		results, err := RunExamples(rule)
+		if err != nil {
+			t.Errorf("rule %s: %v", rule.ID, err)
+			continue
+		}
+		for _, result := range results {
+			if !result.Passed() {
+				t.Errorf("rule %s: example %q: matched = %v, err = %v", rule.ID, result.Example, result.Matched, result.Err)
+			}
+		}
+	}
+}